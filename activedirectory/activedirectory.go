@@ -0,0 +1,191 @@
+// Package activedirectory is a typed client for OIT's Active Directory API,
+// built on top of apihelper.CampusAPIHelper.
+package activedirectory
+
+import (
+	"campus-api-helper/apihelper"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	// BaseURL is the root of every version of the Active Directory API
+	BaseURL = "https://api.princeton.edu:443/active-directory"
+
+	// DefaultVersion is the API version used when none is given
+	DefaultVersion = "1.0.5"
+)
+
+// the maximum number of requests GetUsersBasic has in flight at once
+const maxConcurrentLookups = 8
+
+var (
+	// ErrNotFound is returned when no user matches a lookup
+	ErrNotFound = errors.New("activedirectory: user not found")
+
+	// ErrUnauthorized is returned when the API rejects our credentials
+	ErrUnauthorized = errors.New("activedirectory: unauthorized")
+)
+
+// An APIError is returned when the API answers with an unexpected status.
+// It matches ErrNotFound or ErrUnauthorized under errors.Is where applicable.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       string // the start of the response body, for diagnostics
+}
+
+func (e *APIError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("activedirectory: API returned %v", e.Status)
+	}
+	return fmt.Sprintf("activedirectory: API returned %v: %v", e.Status, e.Body)
+}
+
+// Is reports whether the error matches ErrNotFound or ErrUnauthorized
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// A User is a directory entry as returned by the /users/basic endpoint.
+// Attributes missing from the response are left empty.
+type User struct {
+	UID          string `json:"uid"`          // the netid
+	UniversityID string `json:"universityid"` // the nine-digit Princeton ID
+	DisplayName  string `json:"displayname"`
+	Mail         string `json:"mail"`
+	Department   string `json:"department"`
+	Status       string `json:"pustatus"` // e.g. undergraduate, graduate or faculty
+}
+
+// A Client issues typed requests to one version of the Active Directory API
+type Client struct {
+	helper  *apihelper.CampusAPIHelper
+	baseUrl string
+}
+
+// VersionURL returns the base URL of the given version of the API
+func VersionURL(version string) string {
+	return BaseURL + "/" + version
+}
+
+// NewClient returns a Client that sends its requests through helper to the
+// API rooted at baseUrl. If baseUrl is empty, VersionURL(DefaultVersion) is used.
+func NewClient(helper *apihelper.CampusAPIHelper, baseUrl string) *Client {
+	if baseUrl == "" {
+		baseUrl = VersionURL(DefaultVersion)
+	}
+	return &Client{helper: helper, baseUrl: strings.TrimSuffix(baseUrl, "/")}
+}
+
+// GetUserBasic looks up the user with the given netid.
+// Returns ErrNotFound if there is no such user.
+func (c *Client) GetUserBasic(ctx context.Context, uid string) (*User, error) {
+	users, err := c.SearchUsers(ctx, url.Values{"uid": {uid}})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, uid)
+	}
+	return &users[0], nil
+}
+
+// GetUsersBasic looks up the users with the given netids concurrently.
+// The result holds the users that were found, in the order of uids;
+// netids without a user are skipped. Any other error aborts the lookup,
+// and the first such error to occur is returned.
+func (c *Client) GetUsersBasic(ctx context.Context, uids []string) ([]User, error) {
+	lookupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make([]*User, len(uids))
+	slots := make(chan struct{}, maxConcurrentLookups)
+
+	// the first error, in the order they occur: once it cancels the other
+	// lookups, theirs are only the consequence of it
+	var failOnce sync.Once
+	var failure error
+
+	var wg sync.WaitGroup
+	for i, uid := range uids {
+		wg.Add(1)
+		go func(i int, uid string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			user, err := c.GetUserBasic(lookupCtx, uid)
+			if err != nil {
+				if !errors.Is(err, ErrNotFound) {
+					failOnce.Do(func() {
+						failure = err
+						cancel()
+					})
+				}
+				return
+			}
+			found[i] = user
+		}(i, uid)
+	}
+	wg.Wait()
+
+	if failure != nil {
+		return nil, failure
+	}
+
+	users := make([]User, 0, len(uids))
+	for _, user := range found {
+		if user != nil {
+			users = append(users, *user)
+		}
+	}
+
+	return users, nil
+}
+
+// SearchUsers returns the users whose attributes match the query,
+// e.g. url.Values{"displayname": {"Ada Lovelace"}}. An empty result is
+// not an error.
+func (c *Client) SearchUsers(ctx context.Context, query url.Values) ([]User, error) {
+	res, err := c.helper.GetContext(ctx, c.baseUrl+"/users/basic?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, newAPIError(res)
+	}
+
+	var users []User
+	err = json.NewDecoder(res.Body).Decode(&users)
+	if err != nil {
+		return nil, fmt.Errorf("activedirectory: could not decode response: %w", err)
+	}
+
+	return users, nil
+}
+
+// builds an APIError from an unexpected response
+func newAPIError(res *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	return &APIError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
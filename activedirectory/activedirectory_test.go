@@ -0,0 +1,97 @@
+package activedirectory
+
+import (
+	"campus-api-helper/apihelper"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fixture directory served by the test server
+var directory = map[string]User{
+	"liame": {UID: "liame", UniversityID: "920000001", DisplayName: "Liam E"},
+	"hvera": {UID: "hvera", UniversityID: "920000002", DisplayName: "H Vera"},
+}
+
+// returns a Client backed by a test server serving the fixture directory
+func newTestClient(t *testing.T) *Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/active-directory/1.0.5/users/basic" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		users := []User{}
+		if user, ok := directory[r.URL.Query().Get("uid")]; ok {
+			users = append(users, user)
+		}
+		json.NewEncoder(w).Encode(users)
+	}))
+	t.Cleanup(server.Close)
+
+	helper, err := apihelper.NewCampusAPIHelperFromTokenSource(apihelper.StaticTokenSource(&apihelper.Token{AccessToken: "token"}), server.Client(), 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(helper.Close)
+
+	return NewClient(helper, server.URL+"/active-directory/1.0.5")
+}
+
+// test single and batch lookups, including netids without a user
+func TestGetUsersBasic(t *testing.T) {
+	client := newTestClient(t)
+
+	user, err := client.GetUserBasic(context.Background(), "liame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *user != directory["liame"] {
+		t.Errorf("got %+v, want %+v", *user, directory["liame"])
+	}
+
+	_, err = client.GetUserBasic(context.Background(), "nobody")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+
+	users, err := client.GetUsersBasic(context.Background(), []string{"hvera", "nobody", "liame"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].UID != "hvera" || users[1].UID != "liame" {
+		t.Errorf("got %+v, want hvera and liame", users)
+	}
+}
+
+// test that a failed batch reports the lookup that failed, not the lookups
+// it canceled
+func TestGetUsersBasicReportsFirstFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("uid") {
+		case "slow":
+			<-r.Context().Done()
+		case "bad":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	helper, err := apihelper.NewCampusAPIHelperFromTokenSource(apihelper.StaticTokenSource(&apihelper.Token{AccessToken: "token"}), server.Client(), 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	_, err = NewClient(helper, server.URL).GetUsersBasic(context.Background(), []string{"slow", "bad"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got error %v, want the 500 from looking up bad", err)
+	}
+}
@@ -4,244 +4,702 @@ import (
 	"bufio"
 	"bytes"
 	"campus-api-helper/cache"
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 )
 
+// tokens are treated as expired this long before the expiry reported by the
+// token endpoint (or halfway through the token's lifetime, for tokens that
+// live less than twice as long), so that a token never lapses while a
+// request is in flight
+const expiryDelta = 10 * time.Second
+
+// the background refresh runs this long before a token expires (or halfway
+// through the token's lifetime, for tokens that live less than twice as long)
+const refreshAhead = time.Minute
+
+// how long to wait before retrying a failed background refresh
+const refreshRetryInterval = 5 * time.Second
+
+// how long a background refresh may take before it is abandoned
+const refreshTimeout = 30 * time.Second
+
 // CampusAPIHelper is a wrapper for an HTTP client
 // interacting with Princeton's REST APIs that
 // abstracts away the management of API access tokens.
 type CampusAPIHelper struct {
-	refreshUrl     string // URL to refresh the access token
-	consumerKey    string
-	consumerSecret string
-	accessToken    string
-	lock           *sync.RWMutex
-	client         *http.Client
-	cache          *cache.LRU
+	tokenSource  TokenSource // supplies fresh access tokens
+	accessToken  string
+	tokenType    string
+	expiry       time.Time     // zero if the token never expires
+	expiryDelta  time.Duration // the token counts as expired this long before expiry
+	refreshTimer *time.Timer   // fires the background refresh ahead of expiry
+	closed       bool          // set by Close; stops background refreshes
+	lock         *sync.RWMutex // guards the token fields above
+	refreshLock  chan struct{} // holds a value for the duration of a token refresh
+	client       *http.Client
+	cache        cache.Cache
+	ownsCache    bool        // whether New created the cache, so that Close closes it
+	flights      flightGroup // coalesces concurrent cache misses in Get
+	retryPolicy  RetryPolicy
+	limiter      *rateLimiter  // nil if requests are not rate limited
+	interceptors []Interceptor // run on every request and its response, in order
+	bodyLimit    int64         // the smallest LimitBody among the interceptors; 0 if none
+	baseUrl      *url.URL      // relative request URLs are resolved against it; may be nil
+	metrics      *Metrics
+	tracer       Tracer
+	logger       Logger
 }
 
-// helper struct for unmarshalling access token regeneration responses
-type refreshTokenResponse struct {
-	AccessToken string `json:"access_token"`
-}
+// New returns a new CampusAPIHelper configured by opts. A token source is
+// required, via WithTokenSource or WithClientCredentials; everything else has
+// defaults: http.DefaultClient, a cache.LRU of DefaultCacheSize bytes,
+// DefaultRetryPolicy, no rate limit and DefaultUserAgent.
+//
+// New does not contact the network unless WithEagerToken is given: the first
+// access token is fetched by the first request that needs one.
+func New(opts ...Option) (*CampusAPIHelper, error) {
+	settings := &settings{
+		client:      http.DefaultClient,
+		cacheSize:   DefaultCacheSize,
+		retryPolicy: DefaultRetryPolicy,
+		userAgent:   DefaultUserAgent,
+	}
+
+	for _, opt := range opts {
+		err := opt(settings)
+		if err != nil {
+			return nil, fmt.Errorf("invalid option: %w", err)
+		}
+	}
+
+	if settings.tokenSource == nil {
+		return nil, fmt.Errorf("invalid option: no token source; use WithTokenSource or WithClientCredentials")
+	}
+
+	client := settings.client
+	if settings.timeout > 0 {
+		withTimeout := *client
+		withTimeout.Timeout = settings.timeout
+		client = &withTimeout
+	}
+
+	if ts, ok := settings.tokenSource.(*clientCredentialsTokenSource); ok && ts.client == nil {
+		ts.client = client
+	}
+
+	ownsCache := settings.cache == nil
+	if ownsCache {
+		settings.cache = cache.NewLru(settings.cacheSize)
+	}
+
+	if settings.metrics == nil {
+		settings.metrics = NewMetrics()
+	}
+
+	if settings.tracer == nil {
+		settings.tracer = nopTracer{}
+	}
+
+	if settings.logger == nil {
+		settings.logger = nopLogger{}
+	}
+
+	var interceptors []Interceptor
+	if settings.userAgent != "" {
+		interceptors = append(interceptors, UserAgent(settings.userAgent))
+	}
+	interceptors = append(interceptors, settings.interceptors...)
 
-// factory method that instantiates and returns a new CampusAPIHelper struct
-func NewCampusAPIHelper(consumerKey string, consumerSecret string, refreshUrl string, client *http.Client, cacheSize int) (*CampusAPIHelper, error) {
 	helper := &CampusAPIHelper{
-		refreshUrl:     refreshUrl,
-		consumerKey:    consumerKey,
-		consumerSecret: consumerSecret,
-		lock:           &sync.RWMutex{},
-		client:         client,
-		cache:          cache.NewLru(cacheSize),
+		tokenSource: settings.tokenSource,
+		lock:        &sync.RWMutex{},
+		refreshLock: make(chan struct{}, 1),
+		client:      client,
+		cache:       settings.cache,
+		ownsCache:   ownsCache,
+		retryPolicy: settings.retryPolicy,
+		baseUrl:     settings.baseUrl,
+		metrics:     settings.metrics,
+		tracer:      settings.tracer,
+		logger:      settings.logger,
+
+		interceptors: interceptors,
+		bodyLimit:    bodyLimit(interceptors),
 	}
 
-	if helper.client == nil {
-		helper.client = http.DefaultClient
+	if settings.rateLimit.RequestsPerSecond > 0 {
+		helper.limiter = newRateLimiter(settings.rateLimit)
 	}
 
-	err := helper.refreshAccess()
-	if err != nil {
-		return nil, fmt.Errorf("error obtaining access token: %v", err)
+	if settings.eagerToken {
+		err := helper.refreshAccess(context.Background(), "")
+		if err != nil {
+			return nil, fmt.Errorf("error obtaining access token: %w", err)
+		}
 	}
 
 	return helper, nil
 }
 
-// refreshes the access token
-func (s *CampusAPIHelper) refreshAccess() error {
-	// CONCURRENCY LOGIC:
-	// If the HTTP Request fails:
-	//	 1. try to get the lock (with TryLock)
-	// 	 2. case a - you GOT the lock. refresh the token and then unlock
-	// 		case b - you DID NOT get the lock. wait for the lock to be released (with Lock)
-	//				 and, once it is, immediately release it
-	// 	 3. make the initial request again, now with a fresh access token
-
-	gotLock := s.lock.TryLock()
-	if !gotLock {
-		s.lock.Lock()
-		s.lock.Unlock()
-		return nil
+// factory method that instantiates and returns a new CampusAPIHelper struct
+// authenticating with the client credentials grant against refreshUrl.
+// unlike New, it fetches an access token before returning.
+func NewCampusAPIHelper(consumerKey string, consumerSecret string, refreshUrl string, client *http.Client, cacheSize int) (*CampusAPIHelper, error) {
+	return NewCampusAPIHelperFromTokenSource(ClientCredentialsTokenSource(consumerKey, consumerSecret, refreshUrl, client), client, cacheSize)
+}
+
+// factory method that instantiates and returns a new CampusAPIHelper struct
+// whose access tokens are supplied by tokenSource.
+// unlike New, it fetches an access token before returning.
+func NewCampusAPIHelperFromTokenSource(tokenSource TokenSource, client *http.Client, cacheSize int) (*CampusAPIHelper, error) {
+	return NewCampusAPIHelperWithCache(tokenSource, client, cache.NewLru(cacheSize))
+}
+
+// factory method that instantiates and returns a new CampusAPIHelper struct
+// whose access tokens are supplied by tokenSource and whose Get responses are
+// stored in c, which may be any cache.Cache implementation. if c is nil,
+// responses are not cached at all.
+// unlike New, it fetches an access token before returning.
+func NewCampusAPIHelperWithCache(tokenSource TokenSource, client *http.Client, c cache.Cache) (*CampusAPIHelper, error) {
+	opts := []Option{WithTokenSource(tokenSource), WithEagerToken(), WithoutCache()}
+	if client != nil {
+		opts = append(opts, WithHTTPClient(client))
 	}
+	if c != nil {
+		opts = append(opts, WithCache(c))
+	}
+	return New(opts...)
+}
 
+// Close stops the background token refresh, and the background work of the
+// cache New created, if the helper wasn't given one. The helper remains
+// usable afterwards, but tokens are then only refreshed once they expire.
+// A cache given with WithCache is left for its owner to close.
+func (s *CampusAPIHelper) Close() {
+	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
+	s.closed = true
+	if s.refreshTimer != nil {
+		s.refreshTimer.Stop()
+		s.refreshTimer = nil
+	}
+	s.closeCacheLocked()
+}
 
-	req, err := http.NewRequest("POST", s.refreshUrl, strings.NewReader(data.Encode()))
-	if err != nil {
-		return err
+// closes the cache if the helper created it. s.lock must be held for writing.
+func (s *CampusAPIHelper) closeCacheLocked() {
+	if closer, ok := s.cache.(interface{ Close() }); ok && s.ownsCache {
+		closer.Close()
 	}
-	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(s.consumerKey+":"+s.consumerSecret)))
+	s.ownsCache = false
+}
 
-	response, err := s.client.Do(req)
-	if err != nil {
-		return err
+// SetRetryPolicy replaces the helper's RetryPolicy, which defaults to
+// DefaultRetryPolicy. It must not be called concurrently with requests.
+//
+// Deprecated: pass WithRetryPolicy to New instead.
+func (s *CampusAPIHelper) SetRetryPolicy(policy RetryPolicy) {
+	s.retryPolicy = policy
+}
+
+// SetCache replaces the cache behind Get, e.g. with a persistent cache.Disk.
+// A nil cache disables caching. It must not be called concurrently with requests.
+//
+// Deprecated: pass WithCache or WithoutCache to New instead.
+func (s *CampusAPIHelper) SetCache(c cache.Cache) {
+	if c == nil {
+		c = cache.NewNop()
 	}
+	s.lock.Lock()
+	s.closeCacheLocked()
+	s.lock.Unlock()
+	s.cache = c
+}
 
-	defer response.Body.Close()
+// SetRateLimit paces the helper's requests with a token-bucket limiter.
+// A RateLimit with a rate of zero removes the limiter.
+// It must not be called concurrently with requests.
+//
+// Deprecated: pass WithRateLimit to New instead.
+func (s *CampusAPIHelper) SetRateLimit(limit RateLimit) {
+	s.limiter = nil
+	if limit.RequestsPerSecond > 0 {
+		s.limiter = newRateLimiter(limit)
+	}
+}
 
-	b, err := io.ReadAll(response.Body)
-	if err != nil {
-		return err
+// refreshes the access token, unless it has already been replaced since the
+// caller observed the stale token. gives up waiting for a refresh in progress,
+// or fetching a new token, once ctx is done.
+func (s *CampusAPIHelper) refreshAccess(ctx context.Context, stale string) (err error) {
+	// CONCURRENCY LOGIC:
+	// If the access token expires or an HTTP Request fails with a 401:
+	//	 1. try to get the refresh lock (by sending on the refreshLock channel without blocking)
+	// 	 2. case a - you GOT the lock. if nobody has replaced the stale token in
+	//				 the meantime, refresh it. then unlock
+	// 		case b - you DID NOT get the lock. wait for the lock to be released
+	//				 (or for ctx to be done) and, once it is, immediately release it
+	// 	 3. make the initial request (again), now with a fresh access token
+	//
+	// The token fields themselves are guarded by a separate RWMutex that is
+	// only held while reading or writing them, so requests in flight never
+	// delay a refresh and a refresh never blocks requests with a valid token.
+
+	ctx, span := s.tracer.Start(ctx, "campusapi.refreshAccess")
+	defer func() {
+		spanError(span, err)
+		span.End()
+	}()
+
+	if !s.tryLockRefresh() {
+		span.SetAttribute("campusapi.refresh.waited", true)
+		s.logger.Debug("waiting for access token refresh in progress")
+		select {
+		case s.refreshLock <- struct{}{}:
+			<-s.refreshLock
+			s.logger.Debug("access token refresh in progress finished")
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for access token refresh: %w", ctx.Err())
+		}
 	}
 
-	refreshResponse := &refreshTokenResponse{}
-	err = json.Unmarshal(b, refreshResponse)
+	defer func() { <-s.refreshLock }()
 
+	s.lock.RLock()
+	current := s.accessToken
+	s.lock.RUnlock()
+
+	if current != stale {
+		span.SetAttribute("campusapi.refresh.skipped", true)
+		return nil
+	}
+
+	s.logger.Info("refreshing access token")
+	start := time.Now()
+
+	token, err := s.token(ctx)
+	s.metrics.refresh(err)
 	if err != nil {
+		s.logger.Error("access token refresh failed", "error", redactError(err), "latency", time.Since(start))
 		return err
 	}
 
-	s.accessToken = refreshResponse.AccessToken
+	s.setToken(token)
+
+	s.logger.Info("refreshed access token", "latency", time.Since(start), "expiry", token.Expiry)
 
 	return nil
 }
 
-// helper method to check concurrency pattern in testing.
-// identical to refreshAccess(), but with print statements for debugging.
-func (s *CampusAPIHelper) refreshAccessDebug(i int) error {
-	// CONCURRENCY LOGIC:
-	// If the HTTP Request fails:
-	//	 1. try to get the lock (with TryLock)
-	// 	 2. case a - you GOT the lock. refresh the token and then unlock
-	// 		case b - you DID NOT get the lock. wait for the lock to be released (with Lock)
-	//				 and, once it is, immediately release it
-	// 	 3. make the initial request again, now with a fresh access token
-
-	gotLock := s.lock.TryLock()
-	if !gotLock {
-		fmt.Printf("DID NOT GET LOCK %v \n", i)
-		s.lock.Lock()
-		s.lock.Unlock()
-		fmt.Printf("RETURNED TO ORIGINAL REQUEST WITH NEW TOKEN %v \n", i)
-		return nil
-	}
+// requests a new token from the token source, in a span of its own
+func (s *CampusAPIHelper) token(ctx context.Context) (*Token, error) {
+	ctx, span := s.tracer.Start(ctx, "campusapi.Token")
+	defer span.End()
 
-	fmt.Printf("REFRESHING STARTED ON GOROUTINE %v \n", i)
+	token, err := s.tokenSource.Token(withTracer(ctx, s.tracer))
+	return token, spanError(span, err)
+}
+
+// acquires the refresh lock if it is free, reporting whether it did
+func (s *CampusAPIHelper) tryLockRefresh() bool {
+	select {
+	case s.refreshLock <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
 
+// stores a freshly issued token and schedules its background refresh
+func (s *CampusAPIHelper) setToken(token *Token) {
+	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
+	s.accessToken = token.AccessToken
+	s.tokenType = token.Type()
+	s.expiry = token.Expiry
 
-	req, err := http.NewRequest("POST", s.refreshUrl, strings.NewReader(data.Encode()))
-	if err != nil {
-		return err
+	lifetime := time.Until(s.expiry)
+	s.expiryDelta = expiryDelta
+	if lifetime < 2*expiryDelta {
+		s.expiryDelta = lifetime / 2
+	}
+	if s.expiryDelta < 0 {
+		s.expiryDelta = 0
+	}
+	if s.expiry.IsZero() || lifetime <= 0 {
+		return
 	}
-	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(s.consumerKey+":"+s.consumerSecret)))
 
-	response, err := s.client.Do(req)
-	if err != nil {
-		return err
+	ahead := refreshAhead
+	if lifetime < 2*ahead {
+		ahead = lifetime / 2
+	}
+	s.scheduleRefreshLocked(lifetime - ahead)
+}
+
+// arms the background refresh timer. s.lock must be held for writing.
+func (s *CampusAPIHelper) scheduleRefreshLocked(d time.Duration) {
+	if s.closed {
+		return
+	}
+	if s.refreshTimer != nil {
+		s.refreshTimer.Stop()
 	}
+	s.refreshTimer = time.AfterFunc(d, s.backgroundRefresh)
+}
 
-	defer response.Body.Close()
+// refreshes the access token ahead of its expiry, so that requests never
+// have to wait on (or be rejected because of) an expired token
+func (s *CampusAPIHelper) backgroundRefresh() {
+	s.lock.RLock()
+	stale := s.accessToken
+	s.lock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
 
-	b, err := io.ReadAll(response.Body)
+	err := s.refreshAccess(ctx, stale)
 	if err != nil {
-		return err
+		// requests fall back to refreshing synchronously once the token expires
+		s.lock.Lock()
+		if time.Now().Add(refreshRetryInterval).Before(s.expiry) {
+			s.logger.Warn("background token refresh failed", "retry_in", refreshRetryInterval)
+			s.scheduleRefreshLocked(refreshRetryInterval)
+		}
+		s.lock.Unlock()
 	}
+}
 
-	refreshResponse := &refreshTokenResponse{}
-	err = json.Unmarshal(b, refreshResponse)
+// sets the request's Authorization header to the current access token,
+// refreshing the token first if it has expired. returns the token used.
+func (s *CampusAPIHelper) authorize(req *http.Request) (string, error) {
+	s.lock.RLock()
+	token, expired := s.accessToken, s.expiredLocked()
+	s.lock.RUnlock()
 
-	if err != nil {
-		return err
+	if token == "" || expired {
+		err := s.refreshAccess(req.Context(), token)
+		if err != nil {
+			return token, err
+		}
 	}
 
-	s.accessToken = refreshResponse.AccessToken
+	s.lock.RLock()
+	token, tokenType := s.accessToken, s.tokenType
+	s.lock.RUnlock()
 
-	fmt.Printf("REFRESHING FINISHED ON GOROUTINE %v \n", i)
+	req.Header.Set("Authorization", tokenType+" "+token)
 
-	return nil
+	return token, nil
 }
 
-// execute an HTTP request with API access token authentication
+// reports whether the access token is (about to be) expired.
+// s.lock must be held.
+func (s *CampusAPIHelper) expiredLocked() bool {
+	if s.expiry.IsZero() {
+		return false
+	}
+	return !time.Now().Add(s.expiryDelta).Before(s.expiry)
+}
+
+// execute an HTTP request with API access token authentication,
+// retrying transient failures according to the helper's RetryPolicy
+// and pacing attempts according to its RateLimit.
+// the request's context governs the whole exchange, including token
+// refreshes, rate limiting and the delays between retries.
+// Do sends req through the helper's Transport, bypassing the cache.
 func (s *CampusAPIHelper) Do(req *http.Request) (*http.Response, error) {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+	if !req.URL.IsAbs() && s.baseUrl != nil {
+		req.URL = resolveURL(s.baseUrl, req.URL)
+		req.Host = ""
+	}
+	return (&transport{helper: s}).RoundTrip(req)
+}
 
-	req.Header.Set("Authorization", "Bearer "+s.accessToken)
+// DoContext is like Do, but sends the request with the given context
+func (s *CampusAPIHelper) DoContext(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return s.Do(req.WithContext(ctx))
+}
+
+// sends req with access token authentication, retries and rate limiting on
+// behalf of the helper's transport
+func (s *CampusAPIHelper) do(req *http.Request) (res *http.Response, err error) {
+	ctx, span := s.tracer.Start(req.Context(), "campusapi.Do")
+	attempt := 0
+	defer func() {
+		span.SetAttribute("campusapi.attempts", attempt)
+		if res != nil {
+			span.SetAttribute("http.status_code", res.StatusCode)
+		}
+		spanError(span, err)
+		span.End()
+	}()
+	if ctx != req.Context() {
+		req = req.WithContext(ctx)
+	}
+
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.String())
+
+	for attempt = 1; ; attempt++ {
+		if s.limiter != nil {
+			err := s.limiter.wait(req.Context(), req)
+			if err != nil {
+				return nil, fmt.Errorf("waiting for rate limiter: %w", err)
+			}
+		}
+
+		res, err = s.send(req)
+		if s.limiter != nil && err == nil {
+			s.limiter.observe(req, res)
+		}
+
+		if attempt >= s.retryPolicy.MaxAttempts || !s.retryPolicy.retryable(req, res, err) {
+			return res, err
+		}
+
+		delay, ok := s.retryPolicy.delay(attempt, res)
+		if !ok || !rewindBody(req) {
+			return res, err
+		}
+
+		if res != nil {
+			s.logger.Warn("retrying request", "method", req.Method, "url", redactURL(req.URL), "attempt", attempt, "delay", delay, "status", res.StatusCode)
+			drainBody(res)
+		} else {
+			s.logger.Warn("retrying request", "method", req.Method, "url", redactURL(req.URL), "attempt", attempt, "delay", delay, "error", redactError(err))
+		}
+		s.metrics.retry(req)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, fmt.Errorf("waiting to retry request (attempt %v): %w", attempt, req.Context().Err())
+		}
+	}
+}
+
+// sends a single request with API access token authentication. if the
+// access token is rejected, it is refreshed and the request is sent again.
+func (s *CampusAPIHelper) send(req *http.Request) (*http.Response, error) {
+	token, err := s.authorize(req)
+	if err != nil {
+		return nil, fmt.Errorf("error refreshing access token: %w", err)
+	}
+
+	res, err := s.roundTrip(req)
+	if err != nil {
+		// the client only returns a response along with an error after
+		// closing its body, and callers expect one or the other
+		return nil, err
+	}
 
-	res, err := s.client.Do(req)
 	if res.StatusCode == http.StatusUnauthorized {
-		err = s.refreshAccess()
+		err = s.refreshAccess(req.Context(), token)
 		if err != nil {
-			return res, fmt.Errorf("error refreshing access token %v", err)
+			drainBody(res)
+			return nil, fmt.Errorf("error refreshing access token: %w", err)
 		}
 
-		req.Header.Set("Authorization", "Bearer "+s.accessToken)
-		res, err = s.client.Do(req)
+		if !rewindBody(req) {
+			return res, nil
+		}
+		drainBody(res)
+
+		_, err = s.authorize(req)
+		if err != nil {
+			return nil, fmt.Errorf("error refreshing access token: %w", err)
+		}
+		res, err = s.roundTrip(req)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return res, err
+	return res, nil
+}
+
+// sends a single request through the HTTP client, recording it in the metrics
+// and in a span of its own, which is propagated to the server
+func (s *CampusAPIHelper) roundTrip(req *http.Request) (*http.Response, error) {
+	_, span := s.tracer.Start(req.Context(), "campusapi.roundTrip")
+	defer span.End()
+
+	if sc := span.SpanContext(); sc.IsValid() {
+		req.Header.Set("traceparent", sc.TraceParent())
+	}
+
+	start := time.Now()
+	done := s.metrics.startRequest(req)
+	res, err := s.client.Do(req)
+	done(res)
+
+	if err != nil {
+		s.logger.Warn("request failed", "method", req.Method, "url", redactURL(req.URL), "latency", time.Since(start), "error", redactError(err))
+	} else {
+		s.logger.Debug("request", "method", req.Method, "url", redactURL(req.URL), "status", res.StatusCode, "latency", time.Since(start), "header", redactHeader(req.Header))
+	}
+
+	if res != nil {
+		span.SetAttribute("http.status_code", res.StatusCode)
+	}
+	return res, spanError(span, err)
 }
 
 // issues a GET to the specified URL and caches the result.
-// if the url results in a cache hit, no HTTP request is issued and the
-// cached response body is returned in a new response.
+// if the url results in a fresh cache hit, no HTTP request is issued and the
+// cached response body is returned in a new response. stale cache hits are
+// revalidated with a conditional request. see httpcache.go for the caching rules.
+// concurrent calls that miss the cache for the same url share a single
+// upstream request, and each receives its own copy of the response.
 func (s *CampusAPIHelper) Get(url string) (*http.Response, error) {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+	return s.GetContext(context.Background(), url)
+}
 
-	value, found := s.cache.Get(url)
+// GetContext is like Get, but gives up once ctx is done, whether waiting on
+// the upstream request or on a concurrent call's request for the same url
+func (s *CampusAPIHelper) GetContext(ctx context.Context, rawUrl string) (*http.Response, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", resolveURL(s.baseUrl, u).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.Transport().RoundTrip(req)
+}
+
+// answers a GET on behalf of the helper's transport, from the cache if
+// possible, as described for Get
+func (s *CampusAPIHelper) get(req *http.Request) (res *http.Response, err error) {
+	ctx, span := s.tracer.Start(req.Context(), "campusapi.Get")
+	defer func() {
+		spanError(span, err)
+		span.End()
+	}()
+
+	url := req.URL.String()
+	span.SetAttribute("http.url", url)
+
+	var cached *cachedResponse
+	value, found := s.lookup(ctx, url)
 
 	if found {
-		reader := bufio.NewReader(bytes.NewReader(value))
-		resp, err := http.ReadResponse(reader, nil)
+		cached, err = decodeCachedResponse(value)
 		if err != nil {
-			return resp, err
+			s.cache.Remove(url)
+			cached = nil
+		} else if cached.fresh(time.Now()) {
+			span.SetAttribute("campusapi.cache", "hit")
+			s.logger.Debug("cache lookup", "url", redactURL(req.URL), "result", "hit")
+			return cached.response()
 		}
-		return resp, nil
 	}
 
-	// make new HTTP request if a cache miss
-	req, err := http.NewRequest("GET", url, nil)
+	result := "miss"
+	if cached != nil {
+		result = "stale"
+	}
+	span.SetAttribute("campusapi.cache", result)
+	s.logger.Debug("cache lookup", "url", redactURL(req.URL), "result", result)
+
+	dump, err := s.flights.do(ctx, url, func(ctx context.Context) ([]byte, error) {
+		return s.fetch(req.Clone(ctx), url, cached)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+s.accessToken)
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), req)
+}
 
-	res, err := s.client.Do(req)
-	if res.StatusCode == http.StatusUnauthorized {
-		err = s.refreshAccess()
-		if err != nil {
-			return res, fmt.Errorf("error refreshing access token %v", err)
-		}
+// looks url up in the cache, in a span of its own
+func (s *CampusAPIHelper) lookup(ctx context.Context, url string) ([]byte, bool) {
+	_, span := s.tracer.Start(ctx, "campusapi.cache.Get")
+	defer span.End()
+
+	value, found := s.cache.Get(url)
+	span.SetAttribute("campusapi.cache.hit", found)
+	return value, found
+}
+
+// sends a GET on behalf of get, revalidating the stale cache entry if there
+// is one, and updates the cache entry for url with the result.
+// returns the serialized response.
+func (s *CampusAPIHelper) fetch(req *http.Request, url string, cached *cachedResponse) ([]byte, error) {
+	if cached != nil {
+		cached.addValidators(req)
+	}
 
-		req.Header.Set("Authorization", "Bearer "+s.accessToken)
+	res, err := s.do(req)
+	if err != nil {
+		if res != nil {
+			res.Body.Close()
+		}
+		return nil, err
+	}
 
-		res, err = s.client.Do(req)
+	if cached != nil && res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		err = cached.update(res, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		s.cache.Set(url, cached.encode())
+		return cached.dump, nil
 	}
 
-	body, err := httputil.DumpResponse(res, true)
+	// the response is read whole into the cache entry, so LimitBody has to
+	// be enforced now rather than by its After hook
+	if s.bodyLimit > 0 {
+		err = limitBody(res, s.bodyLimit)
+		if err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	}
 
+	entry, err := newCachedResponse(res, time.Now())
 	if err != nil {
+		res.Body.Close()
 		return nil, err
 	}
 
-	s.cache.Set(url, body)
+	if !isCacheable(res) {
+		if cached != nil {
+			s.cache.Remove(url)
+		}
+		return entry.dump, nil
+	}
+
+	s.cache.Set(url, entry.encode())
 
-	return res, err
+	return entry.dump, nil
 }
 
 // issues a HEAD to the specified URL
 // adapted from go http package source code:
 // https://cs.opensource.google/go/go/+/refs/tags/go1.19.4:src/net/http/client.go;l=919
 func (s *CampusAPIHelper) Head(url string) (*http.Response, error) {
-	req, err := http.NewRequest("HEAD", url, nil)
+	return s.HeadContext(context.Background(), url)
+}
+
+// HeadContext is like Head, but sends the request with the given context
+func (s *CampusAPIHelper) HeadContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -252,7 +710,12 @@ func (s *CampusAPIHelper) Head(url string) (*http.Response, error) {
 // adapted from go http package source code:
 // https://cs.opensource.google/go/go/+/refs/tags/go1.19.4:src/net/http/client.go;l=919
 func (s *CampusAPIHelper) Post(url, contentType string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest("POST", url, body)
+	return s.PostContext(context.Background(), url, contentType, body)
+}
+
+// PostContext is like Post, but sends the request with the given context
+func (s *CampusAPIHelper) PostContext(ctx context.Context, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -265,10 +728,15 @@ func (s *CampusAPIHelper) Post(url, contentType string, body io.Reader) (*http.R
 // adapted from go http package source code:
 // https://cs.opensource.google/go/go/+/refs/tags/go1.19.4:src/net/http/client.go;l=919
 func (s *CampusAPIHelper) PostForm(url string, data url.Values) (*http.Response, error) {
-	return s.Post(url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+	return s.PostFormContext(context.Background(), url, data)
+}
+
+// PostFormContext is like PostForm, but sends the request with the given context
+func (s *CampusAPIHelper) PostFormContext(ctx context.Context, url string, data url.Values) (*http.Response, error) {
+	return s.PostContext(ctx, url, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
 }
 
-// returns the cache's underlying Stats struct
+// returns a snapshot of the cache's statistics
 func (s *CampusAPIHelper) Stats() *cache.Stats {
 	return s.cache.Stats()
 }
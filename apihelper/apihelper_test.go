@@ -1,16 +1,23 @@
 package apihelper
 
 import (
-	"log"
+	"campus-api-helper/apihelper/apihelpertest"
+	"campus-api-helper/cache"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
-
-	"github.com/joho/godotenv"
-)
-
-const (
-	REFRESH_TOKEN_URL = "https://api.princeton.edu:443/token"
 )
 
 /******************************************************************************/
@@ -19,21 +26,1017 @@ const (
 
 // test the concurrency behavior of multiple concurrent access token regeneration attempts
 func TestRefresh(t *testing.T) {
-	godotenv.Load(".env.local")
+	server := apihelpertest.NewServer()
+	defer server.Close()
+
+	logger := &recordingLogger{}
+	testHelper, err := New(WithClientCredentials(apihelpertest.ConsumerKey, apihelpertest.ConsumerSecret, server.TokenURL()), WithHTTPClient(server.Client()), WithEagerToken(), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer testHelper.Close()
+
+	testHelper.lock.RLock()
+	stale := testHelper.accessToken
+	testHelper.lock.RUnlock()
+	logger.reset()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 15; i++ {
+		wg.Add(1)
+		go func(helper *CampusAPIHelper) {
+			defer wg.Done()
+			err := helper.refreshAccess(context.Background(), stale)
+			if err != nil {
+				t.Error(err)
+			}
+		}(testHelper)
+	}
+	wg.Wait()
+
+	// exactly one goroutine refreshes; the others wait for it or find the
+	// token already replaced
+	if started := logger.count("refreshing access token"); started != 1 {
+		t.Errorf("got %v refreshes, want 1", started)
+	}
+	if issued := server.TokensIssued(); issued != 2 {
+		t.Errorf("server issued %v tokens, want 2", issued)
+	}
+}
 
-	consumerKey := os.Getenv("CONSUMER_KEY")
-	consumerSecret := os.Getenv("CONSUMER_SECRET")
+// test that the background refresh replaces a token before it expires, so
+// that concurrent requests never reach the API with an expired access token
+// nor wait on a refresh
+func TestExpiredTokenRefreshedBeforeRequest(t *testing.T) {
+	const lifetime = 11 * time.Second // just above expiryDelta
 
-	testHelper, err := NewCampusAPIHelper(consumerKey, consumerSecret, REFRESH_TOKEN_URL, nil, 100000)
+	var lock sync.Mutex
+	var issued int
+	var rejected int32
+	issuedAt := map[string]time.Time{}
+	used := map[string]int{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		issued++
+		token := fmt.Sprintf("token-%v", issued)
+		issuedAt[token] = time.Now()
+		lock.Unlock()
+		fmt.Fprintf(w, `{"access_token":%q,"token_type":"Bearer","expires_in":%v}`, token, int(lifetime.Seconds()))
+	})
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		lock.Lock()
+		at, ok := issuedAt[token]
+		used[token]++
+		lock.Unlock()
+		if !ok || time.Since(at) > lifetime {
+			atomic.AddInt32(&rejected, 1)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "[]")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	helper, err := NewCampusAPIHelper("key", "secret", server.URL+"/token", server.Client(), 100000)
 	if err != nil {
-		log.Fatalln(err)
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	send := func() {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/users", nil)
+		res, err := helper.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("got status %v, want 200", res.StatusCode)
+		}
 	}
 
+	// fetches the first token, which is refreshed halfway through its lifetime
+	send()
+	time.Sleep(lifetime/2 + 500*time.Millisecond)
+
+	lock.Lock()
+	refreshed := issued
+	lock.Unlock()
+	if refreshed != 2 {
+		t.Fatalf("server issued %v tokens before the first expired, want 2", refreshed)
+	}
+
+	var wg sync.WaitGroup
 	for i := 0; i < 15; i++ {
-		go func(helper *CampusAPIHelper, i int) {
-			helper.refreshAccessDebug(i)
-		}(testHelper, i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send()
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&rejected); n != 0 {
+		t.Errorf("API rejected %v requests with an expired token", n)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if issued != 2 || used["token-2"] != 15 {
+		t.Errorf("server issued %v tokens and saw token-2 %v times, want 2 and 15", issued, used["token-2"])
+	}
+}
+
+// test that a token living less than twice expiryDelta is reused until
+// halfway through its lifetime instead of counting as expired at once
+func TestShortLivedTokenReused(t *testing.T) {
+	var issued int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&issued, 1)
+		fmt.Fprint(w, `{"access_token":"token","token_type":"Bearer","expires_in":4}`)
+	})
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "[]")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	helper, err := NewCampusAPIHelper("key", "secret", server.URL+"/token", server.Client(), 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/users", nil)
+		res, err := helper.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	if n := atomic.LoadInt32(&issued); n != 1 {
+		t.Errorf("server issued %v tokens for 3 requests, want 1", n)
+	}
+}
+
+// test that requests are authenticated with tokens read from a token file
+func TestFileTokenSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(path, []byte(`{"access_token":"from-file","token_type":"bearer"}`+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	helper, err := NewCampusAPIHelperFromTokenSource(FileTokenSource(path), server.Client(), 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	res, err := helper.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, _ := io.ReadAll(res.Body)
+	if string(b) != "Bearer from-file" {
+		t.Errorf("got Authorization %q, want %q", b, "Bearer from-file")
+	}
+}
+
+// test that a static token source without a token fails requests instead of
+// panicking
+func TestStaticTokenSourceNil(t *testing.T) {
+	_, err := StaticTokenSource(nil).Token(context.Background())
+	if err == nil {
+		t.Error("got no error from a nil static token")
+	}
+
+	helper, err := New(WithTokenSource(StaticTokenSource(nil)))
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer helper.Close()
+
+	_, err = helper.Get("http://api.test/")
+	if err == nil {
+		t.Error("got no error from a request without a token")
+	}
+}
+
+// test that Get honors Cache-Control and revalidates stale entries with their ETag
+func TestGetCacheSemantics(t *testing.T) {
+	var hits, revalidated int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fresh", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "fresh")
+	})
+	mux.HandleFunc("/no-store", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprint(w, "no-store")
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/etag", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&revalidated, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprint(w, "etag")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	helper, err := NewCampusAPIHelperFromTokenSource(StaticTokenSource(&Token{AccessToken: "token"}), server.Client(), 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	tests := []struct {
+		path     string
+		wantHits int32
+		wantBody string
+	}{
+		{"/fresh", 1, "fresh"},
+		{"/no-store", 2, "no-store"},
+		{"/error", 2, ""},
+		{"/etag", 2, "etag"},
+	}
+
+	for _, test := range tests {
+		atomic.StoreInt32(&hits, 0)
+		for i := 0; i < 2; i++ {
+			res, err := helper.Get(server.URL + test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if string(b) != test.wantBody {
+				t.Errorf("%v: got body %q, want %q", test.path, b, test.wantBody)
+			}
+		}
+		if n := atomic.LoadInt32(&hits); n != test.wantHits {
+			t.Errorf("%v: server saw %v requests, want %v", test.path, n, test.wantHits)
+		}
+	}
+
+	if atomic.LoadInt32(&revalidated) != 1 {
+		t.Errorf("stale entry was not revalidated with its ETag")
+	}
+}
+
+// test that concurrent cache misses for the same url share one upstream request
+func TestGetCoalescesConcurrentMisses(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprint(w, "coalesced")
+	}))
+	defer server.Close()
+
+	helper, err := NewCampusAPIHelperFromTokenSource(StaticTokenSource(&Token{AccessToken: "token"}), server.Client(), 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 15; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := helper.Get(server.URL + "/users/basic?uid=liame")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer res.Body.Close()
+			b, _ := io.ReadAll(res.Body)
+			if string(b) != "coalesced" {
+				t.Errorf("got body %q, want %q", b, "coalesced")
+			}
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("server saw %v requests, want 1", n)
+	}
+}
+
+// test that transient failures are retried and that request bodies are replayed
+func TestRetryReplaysBody(t *testing.T) {
+	var attempts int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if string(b) != "uid=liame" {
+			t.Errorf("attempt got body %q, want %q", b, "uid=liame")
+		}
+		switch atomic.AddInt32(&attempts, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer server.Close()
+
+	policy := DefaultRetryPolicy
+	policy.BaseDelay = time.Millisecond
+	policy.RetryNonIdempotent = true
+
+	helper, err := New(WithTokenSource(StaticTokenSource(&Token{AccessToken: "token"})), WithHTTPClient(server.Client()), WithRetryPolicy(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	res, err := helper.PostForm(server.URL, url.Values{"uid": {"liame"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || atomic.LoadInt32(&attempts) != 3 {
+		t.Errorf("got status %v after %v attempts, want 200 after 3", res.StatusCode, attempts)
+	}
+}
+
+// test that the backoff doubles with every retry, up to MaxDelay if there
+// is one
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		maxDelay time.Duration
+		want     [4]time.Duration
+	}{
+		{0, [4]time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond}},
+		{300 * time.Millisecond, [4]time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}},
+	}
+
+	for _, test := range tests {
+		policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: test.maxDelay}
+		for i, want := range test.want {
+			delay, ok := policy.delay(i+1, nil)
+			if !ok || delay != want {
+				t.Errorf("MaxDelay %v: delay(%v) = %v, %v; want %v, true", test.maxDelay, i+1, delay, ok, want)
+			}
+		}
+	}
+}
+
+// test that requests are paced by the rate limiter
+func TestRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	helper, err := New(WithTokenSource(StaticTokenSource(&Token{AccessToken: "token"})), WithHTTPClient(server.Client()), WithRateLimit(RateLimit{RequestsPerSecond: 50, Burst: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	start := time.Now()
+	for i := 0; i < 7; i++ {
+		res, err := helper.Head(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	// the burst admits 2 requests at once; the other 5 are 20ms apart, which
+	// makes 100ms, less the time the bucket had already been filling for
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("7 requests took %v, want at least 90ms", elapsed)
+	}
+}
+
+// test that a 429 pauses its bucket until the Retry-After and halves its
+// rate, down to a floor, and that successful responses restore the rate
+func TestRateLimitAdapts(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{RequestsPerSecond: 100, Burst: 5})
+	req := httptest.NewRequest("GET", "http://api.test/users", nil)
+	b := limiter.bucket(req)
+
+	tooMany := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"2"}}}
+	ok := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+
+	limiter.observe(req, tooMany)
+	if b.rate != 50 {
+		t.Errorf("rate after a 429 is %v, want 50", b.rate)
+	}
+	if pause := time.Until(b.pausedUntil); pause < time.Second || pause > 2*time.Second {
+		t.Errorf("paused for %v, want the Retry-After of 2s", pause)
+	}
+
+	// the pause holds back even the requests the burst would have admitted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := limiter.wait(ctx, req)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v while paused, want context.Canceled", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		limiter.observe(req, tooMany)
+	}
+	if b.rate != 100*minRateFraction {
+		t.Errorf("rate after many 429s is %v, want the floor of %v", b.rate, 100*minRateFraction)
+	}
+
+	limiter.observe(req, ok)
+	if want := 100 * (minRateFraction + recoverRateFraction); b.rate != want {
+		t.Errorf("rate after a success is %v, want %v", b.rate, want)
+	}
+	for i := 0; i < 100; i++ {
+		limiter.observe(req, ok)
+	}
+	if b.rate != 100 {
+		t.Errorf("rate after many successes is %v, want the configured 100", b.rate)
+	}
+}
+
+// test that PerHost and PathPrefixes give requests buckets of their own, and
+// that a bucket's requests don't wait on another's
+func TestRateLimitBuckets(t *testing.T) {
+	limiter := newRateLimiter(RateLimit{RequestsPerSecond: 1, PerHost: true, PathPrefixes: []string{"/users", "/users/basic"}})
+	bucket := func(url string) *tokenBucket {
+		return limiter.bucket(httptest.NewRequest("GET", url, nil))
+	}
+
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"http://a.test/users/basic?uid=x", "http://a.test/users/basic/extra", true},
+		{"http://a.test/users/basic", "http://a.test/users/full", false},
+		{"http://a.test/users/full", "http://a.test/users", true},
+		{"http://a.test/users", "http://b.test/users", false},
+		{"http://a.test/token", "http://a.test/", true},
+		{"http://a.test/token", "http://a.test/users", false},
+	}
+
+	for _, test := range tests {
+		if same := bucket(test.a) == bucket(test.b); same != test.same {
+			t.Errorf("%v and %v share a bucket: %v, want %v", test.a, test.b, same, test.same)
+		}
+	}
+
+	// with a burst of 1 and a canceled context, a request is admitted only if
+	// its bucket has a token to spare
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, url := range []string{"http://c.test/users", "http://d.test/users", "http://c.test/token"} {
+		err := limiter.wait(ctx, httptest.NewRequest("GET", url, nil))
+		if err != nil {
+			t.Errorf("%v waited on another bucket: %v", url, err)
+		}
+	}
+	err := limiter.wait(ctx, httptest.NewRequest("GET", "http://c.test/users", nil))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v from an exhausted bucket, want context.Canceled", err)
+	}
+}
+
+// test that a context deadline cancels a slow request and surfaces as such
+func TestGetContextDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	helper, err := NewCampusAPIHelperFromTokenSource(StaticTokenSource(&Token{AccessToken: "token"}), server.Client(), 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = helper.GetContext(ctx, server.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want context.DeadlineExceeded", err)
+	}
+}
 
-	time.Sleep(5 * time.Second)
+// test that a nil cache disables caching in Get
+func TestGetWithoutCache(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+	}))
+	defer server.Close()
+
+	helper, err := NewCampusAPIHelperWithCache(StaticTokenSource(&Token{AccessToken: "token"}), server.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	for i := 0; i < 2; i++ {
+		res, err := helper.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Errorf("server saw %v requests, want 2", n)
+	}
+	if stats := helper.Stats(); stats.Misses != 2 || stats.Hits != 0 {
+		t.Errorf("got %+v, want 2 misses", *stats)
+	}
+}
+
+// test that New validates its options and fetches the first token lazily
+func TestNew(t *testing.T) {
+	var tokens int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokens, 1)
+		fmt.Fprint(w, `{"access_token":"lazy","expires_in":3600}`)
+	})
+	mux.HandleFunc("/active-directory/1.0.5/users/basic", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization")+" "+r.Header.Get("User-Agent"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	_, err := New(WithHTTPClient(server.Client()))
+	if err == nil {
+		t.Errorf("New without a token source succeeded")
+	}
+	_, err = New(WithClientCredentials("key", "secret", server.URL+"/token"), WithRetryPolicy(RetryPolicy{}))
+	if err == nil {
+		t.Errorf("New with a retry policy allowing 0 attempts succeeded")
+	}
+
+	helper, err := New(
+		WithClientCredentials("key", "secret", server.URL+"/token"),
+		WithHTTPClient(server.Client()),
+		WithBaseURL(server.URL+"/active-directory/1.0.5/"),
+		WithUserAgent("campusapi-test"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	if n := atomic.LoadInt32(&tokens); n != 0 {
+		t.Errorf("New fetched %v tokens, want 0", n)
+	}
+
+	res, err := helper.Get("/users/basic?uid=liame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, _ := io.ReadAll(res.Body)
+	if string(b) != "Bearer lazy campusapi-test" {
+		t.Errorf("got %q, want %q", b, "Bearer lazy campusapi-test")
+	}
+	if n := atomic.LoadInt32(&tokens); n != 1 {
+		t.Errorf("fetched %v tokens, want 1", n)
+	}
+}
+
+// test that Close stops the janitor of the cache New created, and leaves a
+// cache it was given alone
+func TestCloseClosesOwnCache(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	helper, err := New(WithTokenSource(StaticTokenSource(&Token{AccessToken: "token"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	helper.cache.(cache.TTLCache).SetWithTTL("k", []byte("v"), time.Minute)
+	if runtime.NumGoroutine() <= before {
+		t.Fatal("the cache's janitor did not start")
+	}
+
+	helper.Close()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%v goroutines still running after Close, want %v", n, before)
+	}
+
+	given := &closeRecordingCache{Cache: cache.NewNop()}
+	helper, err = New(WithTokenSource(StaticTokenSource(&Token{AccessToken: "token"})), WithCache(given))
+	if err != nil {
+		t.Fatal(err)
+	}
+	helper.Close()
+	if given.closed {
+		t.Errorf("Close closed a cache given with WithCache")
+	}
+}
+
+// test that DoContext honors its context, and that the setters predating
+// New still reconfigure a helper
+func TestDoContextAndSetters(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+	}))
+	defer server.Close()
+
+	helper, err := NewCampusAPIHelperFromTokenSource(StaticTokenSource(&Token{AccessToken: "token"}), server.Client(), 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	_, err = helper.DoContext(ctx, req)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+
+	helper.SetRetryPolicy(NoRetryPolicy)
+	helper.SetCache(nil)
+	helper.SetRateLimit(RateLimit{RequestsPerSecond: 1000})
+	for i, want := range []int{503, 200, 200} {
+		res, err := helper.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("request %v: got status %v, want %v", i, res.StatusCode, want)
+		}
+	}
+	if n := atomic.LoadInt32(&hits); n != 3 {
+		t.Errorf("server saw %v requests, want 3", n)
+	}
+}
+
+// test that requests, retries and token refreshes are exported as metrics
+func TestMetricsHandler(t *testing.T) {
+	var attempts int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	policy := DefaultRetryPolicy
+	policy.BaseDelay = time.Millisecond
+
+	helper, err := New(WithTokenSource(StaticTokenSource(&Token{AccessToken: "token"})), WithHTTPClient(server.Client()), WithRetryPolicy(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	res, err := helper.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	rec := httptest.NewRecorder()
+	helper.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	u, _ := url.Parse(server.URL)
+	host := u.Host
+	body := rec.Body.String()
+	for _, line := range []string{
+		`campus_http_requests_total{host="` + host + `",method="GET",code="503"} 1`,
+		`campus_http_requests_total{host="` + host + `",method="GET",code="200"} 1`,
+		`campus_http_request_duration_seconds_count{host="` + host + `",method="GET"} 2`,
+		`campus_http_requests_in_flight{host="` + host + `"} 0`,
+		`campus_http_retries_total{host="` + host + `",method="GET"} 1`,
+		`campus_token_refreshes_total 1`,
+		`campus_token_refresh_failures_total 0`,
+		`campus_cache_misses_total{cache="http"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+// test that requests are logged without their credentials
+func TestLoggerRedactsSecrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	var out strings.Builder
+	helper, err := New(WithTokenSource(StaticTokenSource(&Token{AccessToken: "s3cr3t-token"})), WithHTTPClient(server.Client()), WithLogger(NewTextLogger(&out, LevelDebug)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	res, err := helper.Get(server.URL + "/users?uid=liame&client_secret=hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	logged := out.String()
+	for _, secret := range []string{"s3cr3t-token", "hunter2"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("secret %q was logged:\n%s", secret, logged)
+		}
+	}
+	for _, want := range []string{"msg=\"cache lookup\"", "result=miss", "msg=request", "method=GET", "status=200", "uid=liame", "Authorization:[REDACTED]"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("missing %q in:\n%s", want, logged)
+		}
+	}
+}
+
+// test that any http.Client can send authenticated, cached and retried
+// requests through the helper's Transport
+func TestTransport(t *testing.T) {
+	server := apihelpertest.NewServer()
+	defer server.Close()
+
+	policy := DefaultRetryPolicy
+	policy.BaseDelay = time.Millisecond
+
+	helper, err := New(WithClientCredentials(apihelpertest.ConsumerKey, apihelpertest.ConsumerSecret, server.TokenURL()), WithHTTPClient(server.Client()), WithRetryPolicy(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	client := &http.Client{Transport: helper.Transport()}
+	path := apihelpertest.ActiveDirectoryPath + "/users/basic"
+
+	get := func(uid string) {
+		req, _ := http.NewRequest(http.MethodGet, server.ActiveDirectoryURL()+"/users/basic?uid="+uid, nil)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%v: got status %v, want 200", uid, res.StatusCode)
+		}
+		if req.Header.Get("Authorization") != "" {
+			t.Errorf("%v: the caller's request was modified", uid)
+		}
+	}
+
+	get("liame")
+	get("liame")
+	if n := server.Requests(path); n != 1 {
+		t.Errorf("server saw %v requests for a cached user, want 1", n)
+	}
+
+	server.RevokeTokens()
+	server.Inject(apihelpertest.Fault{Path: path, Status: http.StatusServiceUnavailable, Count: 1})
+	get("hvera")
+	if n := server.Requests(path); n != 4 {
+		t.Errorf("server saw %v requests, want 4", n)
+	}
+	if n := server.TokensIssued(); n != 2 {
+		t.Errorf("server issued %v tokens, want 2", n)
+	}
+}
+
+// test that a rejected token that can't be refreshed fails the round trip
+// with an error and no response, as http.RoundTripper requires
+func TestTransportRefreshFailure(t *testing.T) {
+	server := apihelpertest.NewServer()
+	defer server.Close()
+
+	helper, err := New(WithClientCredentials(apihelpertest.ConsumerKey, apihelpertest.ConsumerSecret, server.TokenURL()), WithHTTPClient(server.Client()), WithRetryPolicy(NoRetryPolicy), WithEagerToken())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	server.RevokeTokens()
+	server.Inject(apihelpertest.Fault{Path: "/token", Status: http.StatusServiceUnavailable})
+
+	req, _ := http.NewRequest(http.MethodPost, server.ActiveDirectoryURL()+"/users/basic", nil)
+	res, err := helper.Transport().RoundTrip(req)
+	if err == nil || res != nil {
+		t.Errorf("got response %v and error %v, want only an error", res != nil, err)
+	}
+	if res != nil {
+		res.Body.Close()
+	}
+}
+
+// test that interceptors modify requests in order and responses in reverse
+// order, and that the built-in ones tag, validate and limit responses
+func TestInterceptors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			fmt.Fprint(w, strings.Repeat("x", 100))
+		case "/teapot":
+			w.WriteHeader(http.StatusTeapot)
+		default:
+			w.Header().Set("Cache-Control", "no-store")
+			fmt.Fprint(w, r.Header.Get("User-Agent")+" "+r.Header.Get("X-Audit")+" "+r.Header.Get("X-Request-ID"))
+		}
+	}))
+	defer server.Close()
+
+	var order []string
+	trace := func(name string) Interceptor {
+		return Interceptor{
+			Before: func(req *http.Request) error { order = append(order, "before "+name); return nil },
+			After:  func(res *http.Response) error { order = append(order, "after "+name); return nil },
+		}
+	}
+	errTeapot := errors.New("teapot")
+
+	helper, err := New(
+		WithTokenSource(StaticTokenSource(&Token{AccessToken: "token"})),
+		WithHTTPClient(server.Client()),
+		WithUserAgent("campusapi-test"),
+		WithInterceptors(
+			trace("first"),
+			RequestID(""),
+			SetHeaders(http.Header{"X-Audit": {"team-a"}}),
+			ValidateResponse(func(res *http.Response) error {
+				if res.StatusCode == http.StatusTeapot {
+					return errTeapot
+				}
+				return nil
+			}),
+			LimitBody(64),
+			trace("second"),
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	res, err := helper.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	fields := strings.Fields(string(b))
+	if len(fields) != 3 || fields[0] != "campusapi-test" || fields[1] != "team-a" || len(fields[2]) != 32 {
+		t.Errorf("server got User-Agent, X-Audit and X-Request-ID %q", b)
+	}
+	if want := "before first,before second,after second,after first"; strings.Join(order, ",") != want {
+		t.Errorf("got hooks %v, want %v", order, want)
+	}
+
+	_, err = helper.Get(server.URL + "/teapot")
+	if !errors.Is(err, errTeapot) {
+		t.Errorf("got error %v, want the validator's", err)
+	}
+
+	_, err = helper.Get(server.URL + "/large")
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("got error %v, want ErrBodyTooLarge", err)
+	}
+}
+
+// test that Get enforces LimitBody while it reads a response for the cache,
+// so that a body without a declared length is neither buffered nor cached
+func TestGetLimitsBodyBeforeCaching(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		for i := 0; i < 100; i++ {
+			fmt.Fprint(w, strings.Repeat("x", 100))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	lru := cache.NewLru(1 << 20)
+	helper, err := New(
+		WithTokenSource(StaticTokenSource(&Token{AccessToken: "token"})),
+		WithHTTPClient(server.Client()),
+		WithCache(lru),
+		WithInterceptors(LimitBody(1000)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	_, err = helper.Get(server.URL)
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("got error %v, want ErrBodyTooLarge", err)
+	}
+	if lru.Len() != 0 {
+		t.Errorf("cached %v responses, want none", lru.Len())
+	}
+}
+
+/******************************************************************************/
+/*                                 Helpers                                    */
+/******************************************************************************/
+
+// a cache.Cache that records whether it was closed
+type closeRecordingCache struct {
+	cache.Cache
+	closed bool
+}
+
+func (c *closeRecordingCache) Close() { c.closed = true }
+
+// a Logger that records the messages it receives
+type recordingLogger struct {
+	m        sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Debug(msg string, args ...any) { l.record(msg) }
+func (l *recordingLogger) Info(msg string, args ...any)  { l.record(msg) }
+func (l *recordingLogger) Warn(msg string, args ...any)  { l.record(msg) }
+func (l *recordingLogger) Error(msg string, args ...any) { l.record(msg) }
+
+func (l *recordingLogger) record(msg string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.messages = append(l.messages, msg)
+}
+
+func (l *recordingLogger) reset() {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	l.messages = nil
+}
+
+// returns how many times msg was logged
+func (l *recordingLogger) count(msg string) int {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	n := 0
+	for _, message := range l.messages {
+		if message == msg {
+			n++
+		}
+	}
+	return n
 }
@@ -0,0 +1,350 @@
+// Package apihelpertest provides a local stand-in for Princeton's API
+// gateway, for tests and offline development that can't reach
+// api.princeton.edu. A Server issues access tokens with the client
+// credentials grant, serves the Active Directory /users/basic endpoint from
+// fixture data, and can be told to delay or fail requests.
+package apihelpertest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ConsumerKey and ConsumerSecret are the client credentials a Server
+	// accepts unless others are set with SetCredentials
+	ConsumerKey    = "test-consumer-key"
+	ConsumerSecret = "test-consumer-secret"
+
+	// DefaultTokenLifetime is how long the tokens a Server issues are valid,
+	// unless set otherwise with SetTokenLifetime
+	DefaultTokenLifetime = time.Hour
+
+	// ActiveDirectoryPath is the path under which a Server serves the
+	// Active Directory API, at the same version as activedirectory.DefaultVersion
+	ActiveDirectoryPath = "/active-directory/1.0.5"
+)
+
+// A User is a directory entry served by the /users/basic endpoint. It
+// encodes to the same JSON as activedirectory.User, which this package can't
+// import: apihelper's own tests use it.
+type User struct {
+	UID          string `json:"uid"`
+	UniversityID string `json:"universityid"`
+	DisplayName  string `json:"displayname"`
+	Mail         string `json:"mail"`
+	Department   string `json:"department"`
+	Status       string `json:"pustatus"`
+}
+
+// Users is the fixture directory a new Server serves
+var Users = []User{
+	{UID: "liame", UniversityID: "920000001", DisplayName: "Liam E", Mail: "liame@princeton.edu", Department: "Computer Science", Status: "undergraduate"},
+	{UID: "hvera", UniversityID: "920000002", DisplayName: "H Vera", Mail: "hvera@princeton.edu", Department: "Computer Science", Status: "undergraduate"},
+	{UID: "sc73", UniversityID: "920000003", DisplayName: "S C", Mail: "sc73@princeton.edu", Department: "Mathematics", Status: "graduate"},
+	{UID: "cabrooks", UniversityID: "920000004", DisplayName: "C A Brooks", Mail: "cabrooks@princeton.edu", Department: "Computer Science", Status: "faculty"},
+}
+
+// A Fault makes a Server misbehave on the requests it matches: it waits
+// Latency before answering, and then answers with Status, if set, instead
+// of handling the request.
+type Fault struct {
+	Path       string        // the request path to match; matches every path if empty
+	Latency    time.Duration // delay before answering
+	Status     int           // e.g. 401, 429 or 503; the request is handled as usual if 0
+	RetryAfter string        // sent as the Retry-After header along with Status, if set
+	Count      int           // number of requests to affect; every request if 0
+}
+
+// Server is a running mock of the API gateway. Its embedded httptest.Server
+// provides URL, Client and Close.
+type Server struct {
+	*httptest.Server
+
+	m              sync.Mutex
+	consumerKey    string
+	consumerSecret string
+	tokenLifetime  time.Duration
+	tokens         map[string]time.Time // issued access tokens and their expiries
+	users          map[string]User
+	faults         []*Fault
+	tokensIssued   int
+	requests       map[string]int // requests received, by path
+}
+
+// NewServer starts and returns a Server accepting ConsumerKey and
+// ConsumerSecret and serving Users. The caller should call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		consumerKey:    ConsumerKey,
+		consumerSecret: ConsumerSecret,
+		tokenLifetime:  DefaultTokenLifetime,
+		tokens:         make(map[string]time.Time),
+		users:          make(map[string]User),
+		requests:       make(map[string]int),
+	}
+	for _, user := range Users {
+		s.users[user.UID] = user
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", s.serveToken)
+	mux.HandleFunc(ActiveDirectoryPath+"/users/basic", s.serveUsersBasic)
+
+	s.Server = httptest.NewServer(s.injectFaults(mux))
+	return s
+}
+
+// TokenURL returns the URL of the token endpoint
+func (s *Server) TokenURL() string {
+	return s.URL + "/token"
+}
+
+// ActiveDirectoryURL returns the base URL of the Active Directory API, for
+// activedirectory.NewClient
+func (s *Server) ActiveDirectoryURL() string {
+	return s.URL + ActiveDirectoryPath
+}
+
+// SetCredentials changes the client credentials the token endpoint accepts
+func (s *Server) SetCredentials(consumerKey, consumerSecret string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.consumerKey = consumerKey
+	s.consumerSecret = consumerSecret
+}
+
+// SetTokenLifetime changes how long tokens issued from now on are valid.
+// The lifetime is reported to clients in whole seconds, rounded up.
+func (s *Server) SetTokenLifetime(lifetime time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.tokenLifetime = lifetime
+}
+
+// RevokeTokens invalidates every token issued so far, as if the gateway had
+// forgotten them: requests bearing them are rejected with a 401
+func (s *Server) RevokeTokens() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.tokens = make(map[string]time.Time)
+}
+
+// AddUser adds user to the directory, replacing any user with the same uid
+func (s *Server) AddUser(user User) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.users[user.UID] = user
+}
+
+// Inject makes the server misbehave as described by fault, in addition to
+// any faults injected before. Faults are applied in the order they were
+// injected; the first one with a Status answers the request.
+func (s *Server) Inject(fault Fault) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults removes every injected fault
+func (s *Server) ClearFaults() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.faults = nil
+}
+
+// TokensIssued returns the number of access tokens issued so far
+func (s *Server) TokensIssued() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.tokensIssued
+}
+
+// Requests returns the number of requests received for path so far,
+// including those answered by a fault
+func (s *Server) Requests(path string) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.requests[path]
+}
+
+// counts the request and applies the faults matching it before passing it on
+func (s *Server) injectFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.m.Lock()
+		s.requests[r.URL.Path]++
+		var latency time.Duration
+		var answer *Fault
+		remaining := s.faults[:0]
+		for _, fault := range s.faults {
+			matches := fault.Path == "" || fault.Path == r.URL.Path
+			if matches && answer == nil {
+				latency += fault.Latency
+				if fault.Status != 0 {
+					answer = fault
+				}
+				if fault.Count > 0 {
+					fault.Count--
+					if fault.Count == 0 {
+						continue
+					}
+				}
+			}
+			remaining = append(remaining, fault)
+		}
+		s.faults = remaining
+		s.m.Unlock()
+
+		if latency > 0 {
+			timer := time.NewTimer(latency)
+			select {
+			case <-timer.C:
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		if answer != nil {
+			if answer.RetryAfter != "" {
+				w.Header().Set("Retry-After", answer.RetryAfter)
+			}
+			http.Error(w, http.StatusText(answer.Status), answer.Status)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// issues an access token with the client credentials grant
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+	if r.PostFormValue("grant_type") != "client_credentials" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	key, secret, ok := r.BasicAuth()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !ok || key != s.consumerKey || secret != s.consumerSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+
+	s.tokensIssued++
+	s.tokens[token] = time.Now().Add(s.tokenLifetime)
+
+	expiresIn := int64((s.tokenLifetime + time.Second - 1) / time.Second)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": token,
+		"token_type":   "bearer",
+		"expires_in":   expiresIn,
+		"scope":        "am_application_scope default",
+	})
+}
+
+// looks up users by any of their attributes, like the real endpoint
+func (s *Server) serveUsersBasic(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+
+	query := r.URL.Query()
+
+	s.m.Lock()
+	users := []User{}
+	for _, user := range s.users {
+		if matches(user, query) {
+			users = append(users, user)
+		}
+	}
+	s.m.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=60")
+	json.NewEncoder(w).Encode(users)
+}
+
+// reports whether the request bears an unexpired token the server issued
+func (s *Server) authorized(r *http.Request) bool {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return false
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	expiry, ok := s.tokens[token]
+	return ok && time.Now().Before(expiry)
+}
+
+// reports whether user matches every attribute in query. a query without
+// any known attribute matches nobody.
+func matches(user User, query map[string][]string) bool {
+	attributes := map[string]string{
+		"uid":          user.UID,
+		"universityid": user.UniversityID,
+		"displayname":  user.DisplayName,
+		"mail":         user.Mail,
+		"department":   user.Department,
+		"pustatus":     user.Status,
+	}
+
+	matched := false
+	for key, values := range query {
+		value, known := attributes[key]
+		if !known {
+			continue
+		}
+		if len(values) == 0 || !strings.EqualFold(values[0], value) {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// writes an OAuth2-style error response
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":%q}`+"\n", code)
+}
@@ -0,0 +1,136 @@
+package apihelpertest
+
+import (
+	"campus-api-helper/activedirectory"
+	"campus-api-helper/apihelper"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// returns a helper and Active Directory client authenticating with s
+func newTestClient(t *testing.T, s *Server, opts ...apihelper.Option) *activedirectory.Client {
+	policy := apihelper.DefaultRetryPolicy
+	policy.BaseDelay = time.Millisecond
+
+	opts = append([]apihelper.Option{
+		apihelper.WithClientCredentials(ConsumerKey, ConsumerSecret, s.TokenURL()),
+		apihelper.WithHTTPClient(s.Client()),
+		apihelper.WithRetryPolicy(policy),
+		apihelper.WithoutCache(),
+	}, opts...)
+
+	helper, err := apihelper.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(helper.Close)
+
+	return activedirectory.NewClient(helper, s.ActiveDirectoryURL())
+}
+
+// test that users are served to an authenticated helper, and that revoked
+// tokens and bad credentials are rejected
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	client := newTestClient(t, s)
+
+	user, err := client.GetUserBasic(context.Background(), "liame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the conversion keeps User's fields in step with activedirectory.User's
+	if *user != activedirectory.User(Users[0]) {
+		t.Errorf("got %+v, want %+v", *user, Users[0])
+	}
+
+	_, err = client.GetUserBasic(context.Background(), "nobody")
+	if !errors.Is(err, activedirectory.ErrNotFound) {
+		t.Errorf("got error %v, want ErrNotFound", err)
+	}
+
+	// a revoked token is rejected once and then replaced
+	s.RevokeTokens()
+	_, err = client.GetUserBasic(context.Background(), "hvera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := s.TokensIssued(); n != 2 {
+		t.Errorf("server issued %v tokens, want 2", n)
+	}
+
+	s.SetCredentials("other-key", "other-secret")
+	s.RevokeTokens()
+	_, err = client.GetUserBasic(context.Background(), "hvera")
+	if err == nil {
+		t.Errorf("request with bad credentials succeeded")
+	}
+}
+
+// test that injected faults are seen, and survived, by the helper
+func TestServerFaults(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	path := ActiveDirectoryPath + "/users/basic"
+	tests := []struct {
+		name         string
+		fault        Fault
+		wantErr      bool
+		wantRequests int
+	}{
+		{"503 retried", Fault{Path: path, Status: http.StatusServiceUnavailable, Count: 2}, false, 3},
+		{"429 retried after delay", Fault{Path: path, Status: http.StatusTooManyRequests, RetryAfter: "0", Count: 1}, false, 2},
+		{"401 refreshes token", Fault{Path: path, Status: http.StatusUnauthorized, Count: 1}, false, 2},
+		{"persistent 502", Fault{Path: path, Status: http.StatusBadGateway}, true, 3},
+		{"500 not retried", Fault{Path: path, Status: http.StatusInternalServerError, Count: 1}, true, 1},
+	}
+
+	client := newTestClient(t, s)
+	for _, test := range tests {
+		s.ClearFaults()
+		before := s.Requests(path)
+		s.Inject(test.fault)
+
+		_, err := client.GetUserBasic(context.Background(), "liame")
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: got error %v, want error: %v", test.name, err, test.wantErr)
+		}
+		if n := s.Requests(path) - before; n != test.wantRequests {
+			t.Errorf("%v: server saw %v requests, want %v", test.name, n, test.wantRequests)
+		}
+	}
+
+	// latency beyond the helper's timeout fails the request
+	s.ClearFaults()
+	s.Inject(Fault{Path: path, Latency: time.Second})
+	client = newTestClient(t, s, apihelper.WithTimeout(50*time.Millisecond), apihelper.WithRetryPolicy(apihelper.NoRetryPolicy))
+	_, err := client.GetUserBasic(context.Background(), "liame")
+	if err == nil {
+		t.Errorf("request slower than the timeout succeeded")
+	}
+}
+
+// test that expired tokens are refused, and refreshed by the helper in time
+func TestServerTokenExpiry(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.SetTokenLifetime(time.Second)
+
+	client := newTestClient(t, s)
+	for i := 0; i < 2; i++ {
+		_, err := client.GetUserBasic(context.Background(), "sc73")
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(1100 * time.Millisecond)
+	}
+
+	if n := s.TokensIssued(); n < 2 {
+		t.Errorf("server issued %v tokens, want at least 2", n)
+	}
+}
@@ -0,0 +1,491 @@
+// Package cassette records the HTTP exchanges of a CampusAPIHelper to a file
+// and replays them, so that tests can run deterministically against
+// responses captured once from the real API.
+//
+// A Recorder is an http.RoundTripper; pass it as the Transport of the client
+// given to apihelper.NewCampusAPIHelper or apihelper.WithHTTPClient:
+//
+//	rec, err := cassette.New("testdata/users.json", cassette.ModeReplay, cassette.Strict())
+//	...
+//	defer rec.Save()
+//	helper, err := apihelper.NewCampusAPIHelper(key, secret, tokenUrl, &http.Client{Transport: rec}, 100000)
+//
+// Credentials are scrubbed before an exchange is stored: the values of
+// credential headers, and of credential query parameters, form fields and
+// JSON fields, such as the access token in a token response, are replaced.
+package cassette
+
+import (
+	"bytes"
+	"campus-api-helper/internal/secrets"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Redacted replaces the values of scrubbed credentials
+const Redacted = secrets.Redacted
+
+// ErrUnrecorded is returned by a strict Recorder for requests that match no
+// recorded interaction
+var ErrUnrecorded = errors.New("cassette: request was not recorded")
+
+// Mode determines whether a Recorder replays or records exchanges
+type Mode int
+
+const (
+	// ModeReplay answers requests with the matching recorded responses.
+	// Requests that match none are sent to the real transport and recorded,
+	// unless the Recorder is Strict.
+	ModeReplay Mode = iota
+
+	// ModeRecord discards the recorded interactions and re-records every
+	// request from the real transport
+	ModeRecord
+)
+
+// ParseMode parses "replay" or "record", such as the value of an environment
+// variable that switches tests to re-recording their cassettes
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "", "replay":
+		return ModeReplay, nil
+	case "record":
+		return ModeRecord, nil
+	}
+	return ModeReplay, fmt.Errorf("cassette: unknown mode %q", s)
+}
+
+// A Cassette is the file format of a Recorder: the recorded interactions,
+// in the order they were recorded
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// An Interaction is a recorded request and the response it received
+type Interaction struct {
+	Request    Request   `json:"request"`
+	Response   Response  `json:"response"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Request is a recorded request, with its credentials scrubbed
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+// Response is a recorded response, with its credentials scrubbed
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is a recorded message body. It is stored as a string if it is valid
+// UTF-8, and base64-encoded otherwise.
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(b)})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*b = Body(s)
+		return nil
+	}
+	var encoded struct {
+		Base64 string `json:"base64"`
+	}
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded.Base64)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// A Matcher reports whether a live request, already scrubbed like a recorded
+// one, matches a recorded request
+type Matcher func(live *Request, recorded *Request) bool
+
+// MatchMethod matches requests with the same method
+func MatchMethod(live *Request, recorded *Request) bool {
+	return live.Method == recorded.Method
+}
+
+// MatchURL matches requests for the same URL, including the query
+func MatchURL(live *Request, recorded *Request) bool {
+	return live.URL == recorded.URL
+}
+
+// MatchBody matches requests with the same body
+func MatchBody(live *Request, recorded *Request) bool {
+	return bytes.Equal(live.Body, recorded.Body)
+}
+
+// MatchAll matches requests that all of matchers match
+func MatchAll(matchers ...Matcher) Matcher {
+	return func(live *Request, recorded *Request) bool {
+		for _, match := range matchers {
+			if !match(live, recorded) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// DefaultMatcher matches requests by method, URL and body
+var DefaultMatcher = MatchAll(MatchMethod, MatchURL, MatchBody)
+
+// An Option configures a Recorder created by New
+type Option func(*Recorder) error
+
+// WithTransport sends the requests that are recorded through transport,
+// instead of http.DefaultTransport
+func WithTransport(transport http.RoundTripper) Option {
+	return func(r *Recorder) error {
+		if transport == nil {
+			return fmt.Errorf("transport must not be nil")
+		}
+		r.transport = transport
+		return nil
+	}
+}
+
+// WithMatcher decides which recorded interaction answers a request with
+// match, instead of DefaultMatcher
+func WithMatcher(match Matcher) Option {
+	return func(r *Recorder) error {
+		if match == nil {
+			return fmt.Errorf("matcher must not be nil")
+		}
+		r.match = match
+		return nil
+	}
+}
+
+// Strict makes a replaying Recorder fail requests that match no recorded
+// interaction with ErrUnrecorded, instead of recording them
+func Strict() Option {
+	return func(r *Recorder) error {
+		r.strict = true
+		return nil
+	}
+}
+
+// WithSecretHeaders scrubs the named headers, in addition to Authorization,
+// Proxy-Authorization, Cookie and Set-Cookie
+func WithSecretHeaders(names ...string) Option {
+	return func(r *Recorder) error {
+		for _, name := range names {
+			r.secretHeaders = append(r.secretHeaders, http.CanonicalHeaderKey(name))
+		}
+		return nil
+	}
+}
+
+// WithSecretFields scrubs the named query parameters, form fields and JSON
+// fields, in addition to access_token, client_secret, code, password,
+// refresh_token and token
+func WithSecretFields(names ...string) Option {
+	return func(r *Recorder) error {
+		for _, name := range names {
+			r.secretFields[strings.ToLower(name)] = true
+		}
+		return nil
+	}
+}
+
+// A Recorder is an http.RoundTripper that replays recorded interactions from
+// a cassette file, or records them there. It is safe for concurrent use.
+type Recorder struct {
+	path          string
+	mode          Mode
+	transport     http.RoundTripper
+	match         Matcher
+	strict        bool
+	secretHeaders []string
+	secretFields  map[string]bool
+
+	m        sync.Mutex
+	cassette *Cassette
+	replayed []bool // which interactions have been replayed
+	changed  bool   // whether interactions were recorded since the last Save
+}
+
+// New returns a Recorder for the cassette file at path. In ModeReplay, the
+// file is loaded, and must exist if the Recorder is Strict; in ModeRecord,
+// it is overwritten by Save.
+func New(path string, mode Mode, opts ...Option) (*Recorder, error) {
+	r := &Recorder{
+		path:          path,
+		mode:          mode,
+		transport:     http.DefaultTransport,
+		match:         DefaultMatcher,
+		secretHeaders: secrets.Headers(),
+		secretFields:  secrets.Fields(),
+		cassette:      &Cassette{},
+	}
+
+	for _, opt := range opts {
+		err := opt(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid option: %w", err)
+		}
+	}
+
+	if mode == ModeReplay {
+		b, err := os.ReadFile(path)
+		if err != nil && (r.strict || !errors.Is(err, os.ErrNotExist)) {
+			return nil, fmt.Errorf("cassette: could not load %v: %w", path, err)
+		}
+		if err == nil {
+			err = json.Unmarshal(b, r.cassette)
+			if err != nil {
+				return nil, fmt.Errorf("cassette: could not decode %v: %w", path, err)
+			}
+		}
+	}
+	r.replayed = make([]bool, len(r.cassette.Interactions))
+
+	return r, nil
+}
+
+// RoundTrip answers req with a recorded response, or sends it through the
+// real transport and records the exchange
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	live := r.scrubRequest(req, body)
+
+	if r.mode == ModeReplay {
+		if interaction := r.find(live); interaction != nil {
+			return interaction.Response.response(req), nil
+		}
+		if r.strict {
+			return nil, fmt.Errorf("%w: %v %v", ErrUnrecorded, live.Method, live.URL)
+		}
+	}
+
+	outbound := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		outbound.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	res, err := r.transport.RoundTrip(outbound)
+	if err != nil {
+		return nil, err
+	}
+
+	resBody, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(resBody))
+
+	r.record(&Interaction{
+		Request: *live,
+		Response: Response{
+			StatusCode: res.StatusCode,
+			Header:     r.scrubHeader(res.Header),
+			Body:       r.scrubBody(res.Header.Get("Content-Type"), resBody),
+		},
+		RecordedAt: time.Now().UTC(),
+	})
+
+	return res, nil
+}
+
+// Save writes the cassette to its file, if any interactions were recorded
+func (r *Recorder) Save() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if !r.changed && r.mode == ModeReplay {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(r.path, append(b, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("cassette: could not save %v: %w", r.path, err)
+	}
+
+	r.changed = false
+	return nil
+}
+
+// Interactions returns the recorded interactions, including those recorded
+// since the cassette was loaded
+func (r *Recorder) Interactions() []*Interaction {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	return append([]*Interaction(nil), r.cassette.Interactions...)
+}
+
+// returns the first matching interaction that hasn't been replayed yet, or
+// else the last matching one, so that repeated requests keep being answered
+func (r *Recorder) find(live *Request) *Interaction {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	last := -1
+	for i, interaction := range r.cassette.Interactions {
+		if !r.match(live, &interaction.Request) {
+			continue
+		}
+		if !r.replayed[i] {
+			r.replayed[i] = true
+			return interaction
+		}
+		last = i
+	}
+	if last < 0 {
+		return nil
+	}
+	return r.cassette.Interactions[last]
+}
+
+// appends a freshly recorded interaction to the cassette
+func (r *Recorder) record(interaction *Interaction) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.replayed = append(r.replayed, true)
+	r.changed = true
+}
+
+// returns a recording of req, whose body has been read into body
+func (r *Recorder) scrubRequest(req *http.Request, body []byte) *Request {
+	return &Request{
+		Method: req.Method,
+		URL:    r.scrubURL(req.URL),
+		Header: r.scrubHeader(req.Header),
+		Body:   r.scrubBody(req.Header.Get("Content-Type"), body),
+	}
+}
+
+// returns u as a string, with its password and credential parameters scrubbed
+func (r *Recorder) scrubURL(u *url.URL) string {
+	scrubbed := *u
+	if _, ok := u.User.Password(); ok {
+		scrubbed.User = url.UserPassword(u.User.Username(), Redacted)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		if r.scrubValues(query) {
+			scrubbed.RawQuery = query.Encode()
+		}
+	}
+	return scrubbed.String()
+}
+
+// returns a copy of header with the values of credential headers scrubbed
+func (r *Recorder) scrubHeader(header http.Header) http.Header {
+	scrubbed := header.Clone()
+	for _, name := range r.secretHeaders {
+		if _, ok := scrubbed[name]; ok {
+			scrubbed[name] = []string{Redacted}
+		}
+	}
+	return scrubbed
+}
+
+// replaces the credential fields in values, reporting whether there were any
+func (r *Recorder) scrubValues(values url.Values) bool {
+	changed := false
+	for key := range values {
+		if r.secretFields[strings.ToLower(key)] {
+			values[key] = []string{Redacted}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// returns body with its credential fields scrubbed, if it is a form or a
+// JSON object
+func (r *Recorder) scrubBody(contentType string, body []byte) Body {
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		values, err := url.ParseQuery(string(body))
+		if err == nil && r.scrubValues(values) {
+			return Body(values.Encode())
+		}
+	case strings.Contains(contentType, "json"):
+		var object map[string]json.RawMessage
+		if json.Unmarshal(body, &object) != nil {
+			break
+		}
+		changed := false
+		for key := range object {
+			if r.secretFields[strings.ToLower(key)] {
+				object[key] = json.RawMessage(`"` + Redacted + `"`)
+				changed = true
+			}
+		}
+		if changed {
+			scrubbed, err := json.Marshal(object)
+			if err == nil {
+				return Body(scrubbed)
+			}
+		}
+	}
+	return Body(body)
+}
+
+// returns a new response to req with the recorded status, header and body
+func (res *Response) response(req *http.Request) *http.Response {
+	header := res.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode)),
+		StatusCode:    res.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(res.Body)),
+		ContentLength: int64(len(res.Body)),
+		Request:       req,
+	}
+}
+
+// reads and closes the request's body, if it has one
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	return io.ReadAll(req.Body)
+}
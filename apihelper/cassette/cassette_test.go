@@ -0,0 +1,112 @@
+package cassette
+
+import (
+	"campus-api-helper/activedirectory"
+	"campus-api-helper/apihelper"
+	"campus-api-helper/apihelper/apihelpertest"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// returns an Active Directory client whose requests go through rec
+func newTestClient(t *testing.T, rec *Recorder, tokenUrl, baseUrl string) *activedirectory.Client {
+	helper, err := apihelper.NewCampusAPIHelper(apihelpertest.ConsumerKey, apihelpertest.ConsumerSecret, tokenUrl, &http.Client{Transport: rec}, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(helper.Close)
+
+	return activedirectory.NewClient(helper, baseUrl)
+}
+
+// test that exchanges recorded against a server are saved without their
+// credentials and replayed once the server is gone
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	server := apihelpertest.NewServer()
+	tokenUrl, baseUrl := server.TokenURL(), server.ActiveDirectoryURL()
+
+	rec, err := New(path, ModeRecord, WithTransport(server.Client().Transport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := newTestClient(t, rec, tokenUrl, baseUrl).GetUserBasic(context.Background(), "liame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rec.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{apihelpertest.ConsumerSecret, "Basic ", "Bearer "} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, b)
+		}
+	}
+	if n := len(rec.Interactions()); n != 2 {
+		t.Errorf("recorded %v interactions, want 2", n)
+	}
+
+	rec, err = New(path, ModeReplay, Strict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, rec, tokenUrl, baseUrl)
+
+	replayed, err := client.GetUserBasic(context.Background(), "liame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *replayed != *recorded {
+		t.Errorf("replayed %+v, want %+v", *replayed, *recorded)
+	}
+
+	_, err = client.GetUserBasic(context.Background(), "hvera")
+	if !errors.Is(err, ErrUnrecorded) {
+		t.Errorf("got error %v, want ErrUnrecorded", err)
+	}
+}
+
+// test that a non-strict replay records the requests it hasn't seen, and
+// that the matcher decides which requests have been seen
+func TestReplayRecordsNewRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	server := apihelpertest.NewServer()
+	defer server.Close()
+
+	ignoreQuery := func(live *Request, recorded *Request) bool {
+		return strings.Split(live.URL, "?")[0] == strings.Split(recorded.URL, "?")[0]
+	}
+
+	rec, err := New(path, ModeReplay, WithTransport(server.Client().Transport), WithMatcher(MatchAll(MatchMethod, ignoreQuery)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestClient(t, rec, server.TokenURL(), server.ActiveDirectoryURL())
+
+	for _, uid := range []string{"liame", "hvera"} {
+		user, err := client.GetUserBasic(context.Background(), uid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the second lookup is answered with the first's recording
+		if user.UID != "liame" {
+			t.Errorf("looked up %v, got %v", uid, user.UID)
+		}
+	}
+
+	if n := server.Requests(apihelpertest.ActiveDirectoryPath + "/users/basic"); n != 1 {
+		t.Errorf("server saw %v lookups, want 1", n)
+	}
+}
@@ -0,0 +1,236 @@
+package apihelper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The response cache behind Get follows the basics of RFC 9111 for a shared
+// cache: only cacheable status codes are stored, "no-store" and "private"
+// responses are never stored, freshness comes from Cache-Control's
+// s-maxage/max-age or from Expires, and stale (or "no-cache") entries are
+// revalidated with the stored ETag and Last-Modified before being reused.
+//
+// Although every request carries an Authorization header, responses are
+// still stored (RFC 9111 section 3.5 would forbid this): the token belongs
+// to the service, not to an end user, so responses are not user-specific.
+
+// responses without explicit freshness information or a Last-Modified date
+// are considered fresh for this long
+const heuristicFreshness = 5 * time.Minute
+
+// status codes that are cacheable by default (RFC 9110 section 15.1)
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// a response stored in the cache, along with the time it was received
+type cachedResponse struct {
+	storedAt time.Time
+	dump     []byte // the response as serialized by httputil.DumpResponse
+	header   http.Header
+}
+
+// serializes a response for storage in the cache. consumes and replaces the
+// response's body.
+func newCachedResponse(res *http.Response, storedAt time.Time) (*cachedResponse, error) {
+	dump, err := httputil.DumpResponse(res, true)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedResponse{storedAt: storedAt, dump: dump, header: res.Header.Clone()}, nil
+}
+
+// deserializes a cache entry produced by encode
+func decodeCachedResponse(value []byte) (*cachedResponse, error) {
+	if len(value) < 8 {
+		return nil, fmt.Errorf("cache entry too short")
+	}
+	entry := &cachedResponse{
+		storedAt: time.Unix(0, int64(binary.BigEndian.Uint64(value[:8]))),
+		dump:     value[8:],
+	}
+	res, err := entry.response()
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	entry.header = res.Header
+	return entry, nil
+}
+
+// serializes the entry for storage in the cache
+func (c *cachedResponse) encode() []byte {
+	value := make([]byte, 8, 8+len(c.dump))
+	binary.BigEndian.PutUint64(value, uint64(c.storedAt.UnixNano()))
+	return append(value, c.dump...)
+}
+
+// returns a new response that reads from the stored copy
+func (c *cachedResponse) response() (*http.Response, error) {
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(c.dump)), nil)
+}
+
+// reports whether the entry may be served without revalidation at time now
+func (c *cachedResponse) fresh(now time.Time) bool {
+	if _, noCache := parseCacheControl(c.header)["no-cache"]; noCache {
+		return false
+	}
+	return c.age(now) < freshnessLifetime(c.header, c.storedAt)
+}
+
+// computes the entry's current age (RFC 9111 section 4.2.3)
+func (c *cachedResponse) age(now time.Time) time.Duration {
+	var age time.Duration
+	if date, err := http.ParseTime(c.header.Get("Date")); err == nil && c.storedAt.After(date) {
+		age = c.storedAt.Sub(date)
+	}
+	if seconds, err := strconv.ParseInt(c.header.Get("Age"), 10, 64); err == nil && time.Duration(seconds)*time.Second > age {
+		age = time.Duration(seconds) * time.Second
+	}
+	return age + now.Sub(c.storedAt)
+}
+
+// adds conditional headers built from the entry's validators to req
+func (c *cachedResponse) addValidators(req *http.Request) {
+	if etag := c.header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := c.header.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// freshens the entry with the headers of a 304 Not Modified response
+// (RFC 9111 section 4.3.4)
+func (c *cachedResponse) update(notModified *http.Response, storedAt time.Time) error {
+	res, err := c.response()
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	for name, values := range notModified.Header {
+		if name == "Content-Length" {
+			continue
+		}
+		res.Header[name] = values
+	}
+
+	updated, err := newCachedResponse(res, storedAt)
+	if err != nil {
+		return err
+	}
+	*c = *updated
+	return nil
+}
+
+// headers that make a request ask for something other than the stored response
+var uncacheableRequestHeaders = []string{"Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"}
+
+// reports whether a request may be answered from, and stored in, the cache
+func isCacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet || (req.Body != nil && req.Body != http.NoBody) {
+		return false
+	}
+	for _, name := range uncacheableRequestHeaders {
+		if req.Header.Get(name) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// reports whether a response to a GET may be stored in the cache
+func isCacheable(res *http.Response) bool {
+	if !cacheableStatus[res.StatusCode] {
+		return false
+	}
+	directives := parseCacheControl(res.Header)
+	if _, noStore := directives["no-store"]; noStore {
+		return false
+	}
+	if _, private := directives["private"]; private {
+		return false
+	}
+	return true
+}
+
+// computes how long a response stays fresh after it is received
+// (RFC 9111 section 4.2.1)
+func freshnessLifetime(header http.Header, storedAt time.Time) time.Duration {
+	directives := parseCacheControl(header)
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[directive]; ok {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds < 0 {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = storedAt
+	}
+
+	if expiresHeader := header.Get("Expires"); expiresHeader != "" {
+		expires, err := http.ParseTime(expiresHeader)
+		if err != nil {
+			// invalid dates, like "0", represent a time in the past
+			return 0
+		}
+		return expires.Sub(date)
+	}
+
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		if date.After(lastModified) {
+			return date.Sub(lastModified) / 10
+		}
+		return 0
+	}
+
+	return heuristicFreshness
+}
+
+// parses the Cache-Control header into a map of directives to their
+// (possibly empty) arguments
+func parseCacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(part, "=")
+			directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return directives
+}
@@ -0,0 +1,176 @@
+package apihelper
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// An Interceptor hooks into every request a CampusAPIHelper sends, whether
+// through Do, Get or Transport, to modify it or its response. Either hook
+// may be nil.
+//
+// Interceptors form an ordered chain, configured with WithInterceptors:
+// Before hooks run in order, before the cache lookup, the access token and
+// the first attempt; After hooks run in reverse order on the final response,
+// after any retries, including responses answered from the cache. An error
+// from a hook fails the request.
+type Interceptor struct {
+	// Before may modify the outgoing request, which is the helper's own copy
+	Before func(req *http.Request) error
+
+	// After may inspect or replace the response's header and body
+	After func(res *http.Response) error
+
+	// set by LimitBody, so that Get can enforce the limit while it reads the
+	// response into the cache, before any After hook runs
+	limit int64
+}
+
+// ErrBodyTooLarge is returned when reading a response body beyond the limit
+// set by LimitBody
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// DefaultRequestIDHeader is the header RequestID sets when given none
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// UserAgent returns an Interceptor that sends userAgent with requests that
+// don't set a User-Agent. New installs one first in the chain for the
+// WithUserAgent setting.
+func UserAgent(userAgent string) Interceptor {
+	return Interceptor{Before: func(req *http.Request) error {
+		if req.Header.Get("User-Agent") == "" {
+			req.Header.Set("User-Agent", userAgent)
+		}
+		return nil
+	}}
+}
+
+// RequestID returns an Interceptor that tags requests that don't already
+// carry one with a random ID in the named header, or in
+// DefaultRequestIDHeader if header is empty. Retries keep the same ID.
+func RequestID(header string) Interceptor {
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+	return Interceptor{Before: func(req *http.Request) error {
+		if req.Header.Get(header) != "" {
+			return nil
+		}
+		id := make([]byte, 16)
+		_, err := rand.Read(id)
+		if err != nil {
+			return fmt.Errorf("generating request ID: %w", err)
+		}
+		req.Header.Set(header, hex.EncodeToString(id))
+		return nil
+	}}
+}
+
+// SetHeaders returns an Interceptor that sets the given headers on every
+// request, replacing any values they already have
+func SetHeaders(header http.Header) Interceptor {
+	header = header.Clone()
+	return Interceptor{Before: func(req *http.Request) error {
+		for name, values := range header {
+			req.Header[name] = append([]string(nil), values...)
+		}
+		return nil
+	}}
+}
+
+// ValidateResponse returns an Interceptor that fails requests whose response
+// validate rejects, e.g. because of its status code or content type
+func ValidateResponse(validate func(res *http.Response) error) Interceptor {
+	return Interceptor{After: validate}
+}
+
+// LimitBody returns an Interceptor that fails requests whose response body
+// is larger than n bytes: at once if the response declares its length, and
+// otherwise with ErrBodyTooLarge from the body's Read once more than n bytes
+// have been read
+func LimitBody(n int64) Interceptor {
+	return Interceptor{
+		After: func(res *http.Response) error { return limitBody(res, n) },
+		limit: n,
+	}
+}
+
+// fails res if it declares a body longer than n bytes, and otherwise makes
+// its body fail once it yields more than n bytes
+func limitBody(res *http.Response, n int64) error {
+	if res.ContentLength > n {
+		return fmt.Errorf("%w: %v bytes, limit is %v", ErrBodyTooLarge, res.ContentLength, n)
+	}
+	res.Body = &limitedBody{body: res.Body, remaining: n}
+	return nil
+}
+
+// returns the smallest limit set by a LimitBody in the chain, or 0 if there
+// is none
+func bodyLimit(interceptors []Interceptor) int64 {
+	var limit int64
+	for _, interceptor := range interceptors {
+		if interceptor.limit > 0 && (limit == 0 || interceptor.limit < limit) {
+			limit = interceptor.limit
+		}
+	}
+	return limit
+}
+
+// a response body that fails once it yields more than its limit
+type limitedBody struct {
+	body      io.ReadCloser
+	remaining int64 // bytes still allowed
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	// read one byte past the limit, to tell a body of exactly n bytes from a longer one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), ErrBodyTooLarge
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
+
+// runs the Before hooks of the chain on req
+func runBefore(interceptors []Interceptor, req *http.Request) error {
+	for _, interceptor := range interceptors {
+		if interceptor.Before == nil {
+			continue
+		}
+		err := interceptor.Before(req)
+		if err != nil {
+			return fmt.Errorf("intercepting request: %w", err)
+		}
+	}
+	return nil
+}
+
+// runs the After hooks of the chain on res, last interceptor first
+func runAfter(interceptors []Interceptor, res *http.Response) error {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		if interceptors[i].After == nil {
+			continue
+		}
+		err := interceptors[i].After(res)
+		if err != nil {
+			return fmt.Errorf("intercepting response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package apihelper
+
+import (
+	"campus-api-helper/internal/secrets"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Logger receives structured log records from a CampusAPIHelper: a message
+// followed by alternating keys and values. Its method set matches that of
+// log/slog's *slog.Logger, which can be passed to WithLogger as is.
+//
+// Requests and cache lookups are logged at Debug, token refreshes at Info,
+// retries and failed requests at Warn, and failed token refreshes at Error.
+// Authorization headers, access tokens and consumer secrets are never logged.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// LogLevel is the minimum severity a logger from NewTextLogger writes
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (level LogLevel) String() string {
+	switch level {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(level)) + ")"
+}
+
+// NewTextLogger returns a Logger that writes records of at least the given
+// level to w, one per line, as key=value pairs in the manner of
+// slog.TextHandler
+func NewTextLogger(w io.Writer, level LogLevel) Logger {
+	return &textLogger{w: w, level: level}
+}
+
+type textLogger struct {
+	m     sync.Mutex
+	w     io.Writer
+	level LogLevel
+}
+
+func (l *textLogger) Debug(msg string, args ...any) { l.log(LevelDebug, msg, args) }
+func (l *textLogger) Info(msg string, args ...any)  { l.log(LevelInfo, msg, args) }
+func (l *textLogger) Warn(msg string, args ...any)  { l.log(LevelWarn, msg, args) }
+func (l *textLogger) Error(msg string, args ...any) { l.log(LevelError, msg, args) }
+
+func (l *textLogger) log(level LogLevel, msg string, args []any) {
+	if level < l.level {
+		return
+	}
+
+	var line strings.Builder
+	line.WriteString("time=" + time.Now().Format(time.RFC3339Nano))
+	line.WriteString(" level=" + level.String())
+	line.WriteString(" msg=" + quoteLogValue(msg))
+	for i := 0; i < len(args); i += 2 {
+		key := fmt.Sprint(args[i])
+		value := "!MISSING"
+		if i+1 < len(args) {
+			value = fmt.Sprint(args[i+1])
+		}
+		line.WriteString(" " + key + "=" + quoteLogValue(value))
+	}
+	line.WriteString("\n")
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	io.WriteString(l.w, line.String())
+}
+
+// quotes value if it would otherwise be ambiguous in a key=value line
+func quoteLogValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+		return strconv.Quote(value)
+	}
+	return value
+}
+
+// the Logger used when none is configured; it discards every record
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...any) {}
+func (nopLogger) Info(msg string, args ...any)  {}
+func (nopLogger) Warn(msg string, args ...any)  {}
+func (nopLogger) Error(msg string, args ...any) {}
+
+// replaces logged values that must stay secret
+const redacted = secrets.Redacted
+
+// headers whose values are credentials
+var secretHeaders = secrets.Headers()
+
+// query parameters whose values are credentials
+var secretParams = secrets.Fields()
+
+// returns u as a string, with its password and credential query parameters redacted
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redactedUrl := *u
+	if _, ok := u.User.Password(); ok {
+		redactedUrl.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		changed := false
+		for key := range query {
+			if secretParams[strings.ToLower(key)] {
+				query[key] = []string{redacted}
+				changed = true
+			}
+		}
+		if changed {
+			redactedUrl.RawQuery = query.Encode()
+		}
+	}
+	return redactedUrl.String()
+}
+
+// returns err's message, with the URL redacted if it is a *url.Error
+func redactError(err error) string {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err.Error()
+	}
+	u, parseErr := url.Parse(urlErr.URL)
+	if parseErr != nil {
+		return urlErr.Op + " " + redacted + ": " + urlErr.Err.Error()
+	}
+	return urlErr.Op + " " + strconv.Quote(redactURL(u)) + ": " + urlErr.Err.Error()
+}
+
+// returns a copy of header with the values of credential headers redacted
+func redactHeader(header http.Header) http.Header {
+	redactedHeader := header.Clone()
+	for _, name := range secretHeaders {
+		if _, ok := redactedHeader[name]; ok {
+			redactedHeader[name] = []string{redacted}
+		}
+	}
+	return redactedHeader
+}
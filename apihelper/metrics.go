@@ -0,0 +1,216 @@
+package apihelper
+
+import (
+	"bufio"
+	"campus-api-helper/cache"
+	"campus-api-helper/internal/promtext"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// upper bounds, in seconds, of the request latency histogram buckets
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics counts what one or more CampusAPIHelpers do on the wire, and serves
+// the counts in the Prometheus text exposition format. Every request sent to
+// the server is counted, including retries and requests resent after a token
+// refresh; responses served from the cache are not.
+type Metrics struct {
+	m               sync.Mutex
+	requests        map[requestLabels]int        // by host, method and status code
+	latencies       map[requestLabels]*histogram // by host and method
+	inFlight        map[string]int               // by host
+	retries         map[requestLabels]int        // by host and method
+	refreshes       int
+	refreshFailures int
+}
+
+// the labels a request is counted under
+type requestLabels struct {
+	host   string
+	method string
+	code   string
+}
+
+// a cumulative latency histogram
+type histogram struct {
+	counts []int // per bucket of latencyBuckets, plus one for +Inf
+	sum    float64
+	count  int
+}
+
+// NewMetrics returns an empty Metrics, which may be shared by several
+// helpers via WithMetrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  make(map[requestLabels]int),
+		latencies: make(map[requestLabels]*histogram),
+		inFlight:  make(map[string]int),
+		retries:   make(map[requestLabels]int),
+	}
+}
+
+// records the start of a request to the server. the returned function
+// records its outcome: the status code of res, or "error" if there is none.
+func (metrics *Metrics) startRequest(req *http.Request) func(res *http.Response) {
+	host := req.URL.Host
+	start := time.Now()
+
+	metrics.m.Lock()
+	metrics.inFlight[host]++
+	metrics.m.Unlock()
+
+	return func(res *http.Response) {
+		elapsed := time.Since(start).Seconds()
+		code := "error"
+		if res != nil {
+			code = strconv.Itoa(res.StatusCode)
+		}
+
+		metrics.m.Lock()
+		defer metrics.m.Unlock()
+
+		metrics.inFlight[host]--
+		metrics.requests[requestLabels{host, req.Method, code}]++
+
+		labels := requestLabels{host: host, method: req.Method}
+		latency := metrics.latencies[labels]
+		if latency == nil {
+			latency = &histogram{counts: make([]int, len(latencyBuckets)+1)}
+			metrics.latencies[labels] = latency
+		}
+		latency.observe(elapsed)
+	}
+}
+
+// records that a request is about to be retried
+func (metrics *Metrics) retry(req *http.Request) {
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+
+	metrics.retries[requestLabels{host: req.URL.Host, method: req.Method}]++
+}
+
+// records a token refresh and whether it failed
+func (metrics *Metrics) refresh(err error) {
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+
+	metrics.refreshes++
+	if err != nil {
+		metrics.refreshFailures++
+	}
+}
+
+func (h *histogram) observe(seconds float64) {
+	i := sort.SearchFloat64s(latencyBuckets, seconds)
+	h.counts[i]++
+	h.sum += seconds
+	h.count++
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text exposition format
+func (metrics *Metrics) WritePrometheus(w io.Writer) error {
+	metrics.m.Lock()
+	defer metrics.m.Unlock()
+
+	out := bufio.NewWriter(w)
+
+	promtext.WriteHeader(out, "campus_http_requests_total", "counter", "Requests sent to the server, by status code.")
+	for _, labels := range sortedLabels(metrics.requests) {
+		promtext.WriteSample(out, "campus_http_requests_total", labels.pairs(), float64(metrics.requests[labels]))
+	}
+
+	promtext.WriteHeader(out, "campus_http_request_duration_seconds", "histogram", "Time from sending a request to receiving its response headers.")
+	latencyLabels := make([]requestLabels, 0, len(metrics.latencies))
+	for labels := range metrics.latencies {
+		latencyLabels = append(latencyLabels, labels)
+	}
+	sortLabels(latencyLabels)
+	for _, labels := range latencyLabels {
+		latency := metrics.latencies[labels]
+		cumulative := 0
+		for i, bound := range latencyBuckets {
+			cumulative += latency.counts[i]
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			promtext.WriteSample(out, "campus_http_request_duration_seconds_bucket", append(labels.pairs(), "le", le), float64(cumulative))
+		}
+		promtext.WriteSample(out, "campus_http_request_duration_seconds_bucket", append(labels.pairs(), "le", "+Inf"), float64(latency.count))
+		promtext.WriteSample(out, "campus_http_request_duration_seconds_sum", labels.pairs(), latency.sum)
+		promtext.WriteSample(out, "campus_http_request_duration_seconds_count", labels.pairs(), float64(latency.count))
+	}
+
+	promtext.WriteHeader(out, "campus_http_requests_in_flight", "gauge", "Requests sent to the server still awaiting a response.")
+	hosts := make([]string, 0, len(metrics.inFlight))
+	for host := range metrics.inFlight {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		promtext.WriteSample(out, "campus_http_requests_in_flight", []string{"host", host}, float64(metrics.inFlight[host]))
+	}
+
+	promtext.WriteHeader(out, "campus_http_retries_total", "counter", "Requests retried after a transient failure.")
+	for _, labels := range sortedLabels(metrics.retries) {
+		promtext.WriteSample(out, "campus_http_retries_total", labels.pairs(), float64(metrics.retries[labels]))
+	}
+
+	promtext.WriteHeader(out, "campus_token_refreshes_total", "counter", "Access tokens requested from the token source.")
+	promtext.WriteSample(out, "campus_token_refreshes_total", nil, float64(metrics.refreshes))
+	promtext.WriteHeader(out, "campus_token_refresh_failures_total", "counter", "Access token requests that failed.")
+	promtext.WriteSample(out, "campus_token_refresh_failures_total", nil, float64(metrics.refreshFailures))
+
+	return out.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format
+func (metrics *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", cache.PrometheusContentType)
+	metrics.WritePrometheus(w)
+}
+
+// returns the label names and values, in order, skipping empty ones
+func (labels requestLabels) pairs() []string {
+	pairs := []string{"host", labels.host, "method", labels.method}
+	if labels.code != "" {
+		pairs = append(pairs, "code", labels.code)
+	}
+	return pairs
+}
+
+func sortedLabels(counts map[requestLabels]int) []requestLabels {
+	labels := make([]requestLabels, 0, len(counts))
+	for l := range counts {
+		labels = append(labels, l)
+	}
+	sortLabels(labels)
+	return labels
+}
+
+func sortLabels(labels []requestLabels) {
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.host != b.host {
+			return a.host < b.host
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.code < b.code
+	})
+}
+
+// MetricsHandler returns an http.Handler, to be mounted at /metrics, that
+// serves the helper's request metrics followed by its cache's statistics
+// (labelled cache="http") in the Prometheus text exposition format
+func (s *CampusAPIHelper) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", cache.PrometheusContentType)
+		s.metrics.WritePrometheus(w)
+		cache.WritePrometheus(w, map[string]cache.Cache{"http": s.cache})
+	})
+}
@@ -0,0 +1,258 @@
+package apihelper
+
+import (
+	"campus-api-helper/cache"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultCacheSize is the capacity, in bytes, of the cache.LRU that New
+// creates when no cache is configured
+const DefaultCacheSize = 10 << 20
+
+// DefaultUserAgent is sent with requests that don't set a User-Agent
+const DefaultUserAgent = "campus-api-helper"
+
+// An Option configures a CampusAPIHelper created by New
+type Option func(*settings) error
+
+// the configuration New builds a CampusAPIHelper from
+type settings struct {
+	tokenSource TokenSource
+	client      *http.Client
+	timeout     time.Duration
+	cache       cache.Cache
+	cacheSize   int
+	retryPolicy RetryPolicy
+	rateLimit   RateLimit
+	userAgent   string
+	baseUrl     *url.URL
+	eagerToken  bool
+	metrics     *Metrics
+	tracer      Tracer
+	logger      Logger
+
+	interceptors []Interceptor
+}
+
+// WithTokenSource authenticates requests with tokens from ts
+func WithTokenSource(ts TokenSource) Option {
+	return func(s *settings) error {
+		if ts == nil {
+			return fmt.Errorf("token source must not be nil")
+		}
+		s.tokenSource = ts
+		return nil
+	}
+}
+
+// WithClientCredentials authenticates requests with tokens obtained from
+// tokenUrl with the client credentials grant, like NewCampusAPIHelper
+func WithClientCredentials(consumerKey, consumerSecret, tokenUrl string) Option {
+	return func(s *settings) error {
+		if consumerKey == "" || consumerSecret == "" {
+			return fmt.Errorf("consumer key and secret must not be empty")
+		}
+		if _, err := url.ParseRequestURI(tokenUrl); err != nil {
+			return fmt.Errorf("invalid token URL: %w", err)
+		}
+		// the client is only known once all options are applied
+		s.tokenSource = &clientCredentialsTokenSource{
+			consumerKey:    consumerKey,
+			consumerSecret: consumerSecret,
+			tokenUrl:       tokenUrl,
+		}
+		return nil
+	}
+}
+
+// WithHTTPClient sends requests (and token requests from WithClientCredentials)
+// through client instead of http.DefaultClient
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *settings) error {
+		if client == nil {
+			return fmt.Errorf("HTTP client must not be nil")
+		}
+		s.client = client
+		return nil
+	}
+}
+
+// WithTimeout bounds every exchange with the server, including redirects
+// and reading the response body, as http.Client.Timeout does
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *settings) error {
+		if timeout <= 0 {
+			return fmt.Errorf("timeout must be positive, got %v", timeout)
+		}
+		s.timeout = timeout
+		return nil
+	}
+}
+
+// WithCache stores the responses of Get in c, which may be any cache.Cache
+func WithCache(c cache.Cache) Option {
+	return func(s *settings) error {
+		if c == nil {
+			return fmt.Errorf("cache must not be nil; use WithoutCache to disable caching")
+		}
+		s.cache = c
+		return nil
+	}
+}
+
+// WithCacheSize stores the responses of Get in a cache.LRU of the given
+// capacity in bytes, instead of DefaultCacheSize
+func WithCacheSize(bytes int) Option {
+	return func(s *settings) error {
+		if bytes < 0 {
+			return fmt.Errorf("cache size must not be negative, got %v", bytes)
+		}
+		s.cache = nil
+		s.cacheSize = bytes
+		return nil
+	}
+}
+
+// WithoutCache disables caching in Get
+func WithoutCache() Option {
+	return func(s *settings) error {
+		s.cache = cache.NewNop()
+		return nil
+	}
+}
+
+// WithRetryPolicy retries failed requests according to policy instead of
+// DefaultRetryPolicy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(s *settings) error {
+		if policy.MaxAttempts < 1 {
+			return fmt.Errorf("retry policy must allow at least 1 attempt, got %v", policy.MaxAttempts)
+		}
+		if policy.BaseDelay < 0 || policy.MaxDelay < 0 {
+			return fmt.Errorf("retry delays must not be negative")
+		}
+		if policy.Jitter < 0 || policy.Jitter > 1 {
+			return fmt.Errorf("retry jitter must be between 0 and 1, got %v", policy.Jitter)
+		}
+		s.retryPolicy = policy
+		return nil
+	}
+}
+
+// WithRateLimit paces requests with a token-bucket limiter
+func WithRateLimit(limit RateLimit) Option {
+	return func(s *settings) error {
+		if limit.RequestsPerSecond <= 0 {
+			return fmt.Errorf("rate limit must be positive, got %v", limit.RequestsPerSecond)
+		}
+		if limit.Burst < 0 {
+			return fmt.Errorf("rate limit burst must not be negative, got %v", limit.Burst)
+		}
+		s.rateLimit = limit
+		return nil
+	}
+}
+
+// WithUserAgent sends userAgent with requests that don't set a User-Agent,
+// instead of DefaultUserAgent
+func WithUserAgent(userAgent string) Option {
+	return func(s *settings) error {
+		s.userAgent = userAgent
+		return nil
+	}
+}
+
+// WithBaseURL resolves relative request URLs, such as "users/basic?uid=liame",
+// by appending them to baseUrl
+func WithBaseURL(baseUrl string) Option {
+	return func(s *settings) error {
+		u, err := url.Parse(baseUrl)
+		if err != nil {
+			return fmt.Errorf("invalid base URL: %w", err)
+		}
+		if !u.IsAbs() || u.Host == "" {
+			return fmt.Errorf("base URL %q must be absolute", baseUrl)
+		}
+		s.baseUrl = u
+		return nil
+	}
+}
+
+// WithMetrics records the helper's requests and token refreshes in metrics,
+// which may be shared with other helpers, instead of in its own Metrics
+func WithMetrics(metrics *Metrics) Option {
+	return func(s *settings) error {
+		if metrics == nil {
+			return fmt.Errorf("metrics must not be nil")
+		}
+		s.metrics = metrics
+		return nil
+	}
+}
+
+// WithTracer starts spans with tracer around requests, cache lookups and
+// token refreshes, and propagates them to the server in a traceparent header
+func WithTracer(tracer Tracer) Option {
+	return func(s *settings) error {
+		if tracer == nil {
+			return fmt.Errorf("tracer must not be nil")
+		}
+		s.tracer = tracer
+		return nil
+	}
+}
+
+// WithLogger logs requests, cache lookups and token refreshes to logger,
+// which may be a *slog.Logger
+func WithLogger(logger Logger) Option {
+	return func(s *settings) error {
+		if logger == nil {
+			return fmt.Errorf("logger must not be nil")
+		}
+		s.logger = logger
+		return nil
+	}
+}
+
+// WithInterceptors appends interceptors to the chain every request and its
+// response pass through. The chain starts with a UserAgent interceptor for
+// the WithUserAgent setting.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(s *settings) error {
+		for _, interceptor := range interceptors {
+			if interceptor.Before == nil && interceptor.After == nil {
+				return fmt.Errorf("interceptor must have a Before or After hook")
+			}
+		}
+		s.interceptors = append(s.interceptors, interceptors...)
+		return nil
+	}
+}
+
+// WithEagerToken makes New fetch an access token before returning, so that
+// bad credentials are reported immediately. By default the first token is
+// fetched by the first request.
+func WithEagerToken() Option {
+	return func(s *settings) error {
+		s.eagerToken = true
+		return nil
+	}
+}
+
+// returns u resolved against base: absolute URLs are returned as they are,
+// and relative ones are appended to base's path
+func resolveURL(base *url.URL, u *url.URL) *url.URL {
+	if base == nil || u.IsAbs() {
+		return u
+	}
+	resolved := *base
+	resolved.Path = strings.TrimSuffix(base.Path, "/") + "/" + strings.TrimPrefix(u.Path, "/")
+	resolved.RawPath = ""
+	resolved.RawQuery = u.RawQuery
+	resolved.Fragment = u.Fragment
+	return &resolved
+}
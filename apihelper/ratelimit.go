@@ -0,0 +1,188 @@
+package apihelper
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A RateLimit configures the token-bucket limiter that paces outbound
+// requests to stay within the API gateway's per-consumer-key limits.
+// Requests wait for a token before every attempt, including retries.
+//
+// When the server answers 429 Too Many Requests, the affected bucket pauses
+// until the response's Retry-After (if any) and halves its rate. The rate then
+// recovers gradually with every successful response.
+type RateLimit struct {
+	RequestsPerSecond float64 // sustained rate; zero or less disables limiting
+	Burst             int     // requests that may be sent at once; at least 1
+
+	// PerHost gives every host its own bucket instead of sharing one
+	PerHost bool
+
+	// PathPrefixes give requests whose path starts with one of the prefixes
+	// a bucket of their own (per host, if PerHost is set). The longest
+	// matching prefix wins; other requests share the remaining bucket.
+	PathPrefixes []string
+}
+
+// the fraction of the configured rate a bucket never throttles below
+const minRateFraction = 0.1
+
+// the fraction of the configured rate a bucket recovers per successful response
+const recoverRateFraction = 0.05
+
+// a rateLimiter holds the token buckets for a RateLimit
+type rateLimiter struct {
+	config  RateLimit
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(config RateLimit) *rateLimiter {
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+	return &rateLimiter{config: config, buckets: make(map[string]*tokenBucket)}
+}
+
+// returns the bucket that paces req, creating it if needed
+func (l *rateLimiter) bucket(req *http.Request) *tokenBucket {
+	key := ""
+	if l.config.PerHost {
+		key = req.URL.Host
+	}
+	prefix := ""
+	for _, candidate := range l.config.PathPrefixes {
+		if strings.HasPrefix(req.URL.Path, candidate) && len(candidate) > len(prefix) {
+			prefix = candidate
+		}
+	}
+	key += prefix
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{
+			limit:  l.config.RequestsPerSecond,
+			rate:   l.config.RequestsPerSecond,
+			burst:  float64(l.config.Burst),
+			tokens: float64(l.config.Burst),
+			last:   time.Now(),
+		}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// blocks until req may be sent or ctx is done
+func (l *rateLimiter) wait(ctx context.Context, req *http.Request) error {
+	return l.bucket(req).wait(ctx)
+}
+
+// adapts the pace of req's bucket to the server's response
+func (l *rateLimiter) observe(req *http.Request, res *http.Response) {
+	b := l.bucket(req)
+	if res.StatusCode == http.StatusTooManyRequests {
+		retryAfter, _ := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+		b.throttle(retryAfter)
+	} else {
+		b.recover()
+	}
+}
+
+// a tokenBucket admits rate requests per second on average, and up to burst
+// requests at once. waiting requests reserve their token up front, so they
+// are admitted in arrival order.
+type tokenBucket struct {
+	lock        sync.Mutex
+	limit       float64   // configured rate
+	rate        float64   // current rate, lowered after a 429
+	burst       float64   // bucket capacity
+	tokens      float64   // available tokens; negative when reserved ahead
+	last        time.Time // when tokens was last brought up to date
+	pausedUntil time.Time // no tokens are handed out before this time
+}
+
+// brings the token count up to date. b.lock must be held.
+func (b *tokenBucket) advance(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// takes a token, waiting for one to become available unless ctx is done first
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.lock.Lock()
+	now := time.Now()
+	b.advance(now)
+	b.tokens--
+
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	if pause := b.pausedUntil.Sub(now); pause > delay {
+		delay = pause
+	}
+	b.lock.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// hand the reserved token back to the requests behind us
+		b.lock.Lock()
+		b.tokens++
+		b.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// pauses the bucket for the given duration and halves its rate
+func (b *tokenBucket) throttle(pause time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	b.advance(now)
+	b.rate /= 2
+	if floor := b.limit * minRateFraction; b.rate < floor {
+		b.rate = floor
+	}
+	if until := now.Add(pause); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+	if b.tokens > 0 {
+		b.tokens = 0
+	}
+}
+
+// lets a throttled bucket's rate creep back towards the configured rate
+func (b *tokenBucket) recover() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.rate < b.limit {
+		b.advance(time.Now())
+		b.rate += b.limit * recoverRateFraction
+		if b.rate > b.limit {
+			b.rate = b.limit
+		}
+	}
+}
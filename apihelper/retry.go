@@ -0,0 +1,172 @@
+package apihelper
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// A RetryPolicy determines which failed requests Do retries, and how long it
+// waits between attempts. Delays grow exponentially from BaseDelay up to
+// MaxDelay, each randomized by Jitter. A Retry-After header on the response
+// takes precedence over the computed delay; if it asks for a longer wait than
+// MaxDelay, the response is returned instead of retried.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts, including the first; 1 disables retries
+	BaseDelay   time.Duration // delay before the second attempt
+	MaxDelay    time.Duration // upper bound on any single delay
+	Jitter      float64       // fraction of each delay that is randomized, from 0 to 1
+
+	// RetryableStatus lists the response status codes that are retried
+	RetryableStatus []int
+
+	// RetryableError reports whether a transport error is retried.
+	// If nil, IsTransientError is used.
+	RetryableError func(error) bool
+
+	// RetryNonIdempotent allows retrying requests with non-idempotent
+	// methods, such as POST, which the server may have acted on already
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy retries transient network errors, 429s and the 5xx
+// responses typical of an overloaded or restarting API gateway.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:     3,
+	BaseDelay:       200 * time.Millisecond,
+	MaxDelay:        5 * time.Second,
+	Jitter:          0.5,
+	RetryableStatus: []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+}
+
+// NoRetryPolicy makes Do send every request exactly once (besides the resend
+// after refreshing a rejected access token).
+var NoRetryPolicy = RetryPolicy{MaxAttempts: 1}
+
+// IsTransientError reports whether err is a network error that is likely to
+// succeed on a second attempt: timeouts, refused or reset connections, and
+// connections closed before a complete response arrived. Context
+// cancellation is never transient.
+func IsTransientError(err error) bool {
+	if err == nil || isContextError(err) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// reports whether the outcome of an attempt should be retried
+func (p *RetryPolicy) retryable(req *http.Request, res *http.Response, err error) bool {
+	if !p.RetryNonIdempotent && !isIdempotent(req.Method) {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if err != nil {
+		if p.RetryableError != nil {
+			return p.RetryableError(err)
+		}
+		return IsTransientError(err)
+	}
+
+	for _, status := range p.RetryableStatus {
+		if res.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
+
+// computes the delay before the given retry (1 for the first retry).
+// ok is false if the server asked for a longer delay than the policy allows.
+func (p *RetryPolicy) delay(retry int, res *http.Response) (delay time.Duration, ok bool) {
+	delay = p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(p.Jitter * rand.Float64() * float64(delay))
+	}
+
+	if res != nil {
+		if retryAfter, found := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); found {
+			if p.MaxDelay > 0 && retryAfter > p.MaxDelay {
+				return 0, false
+			}
+			if retryAfter > delay {
+				delay = retryAfter
+			}
+		}
+	}
+
+	return delay, true
+}
+
+// parses a Retry-After header, given either in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if date.Before(now) {
+			return 0, true
+		}
+		return date.Sub(now), true
+	}
+	return 0, false
+}
+
+// reports whether requests with the given method may safely be sent twice
+func isIdempotent(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// replaces the request's consumed body with a fresh copy, so that it can be
+// sent again. returns false if the body cannot be replayed.
+func rewindBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	req.Body = body
+	return true
+}
+
+// reads the rest of a response body we're discarding and closes it, so that
+// its connection can be reused
+func drainBody(res *http.Response) {
+	io.CopyN(io.Discard, res.Body, 4<<10)
+	res.Body.Close()
+}
@@ -0,0 +1,73 @@
+package apihelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// an in-flight or completed flightGroup.do call
+type call struct {
+	done chan struct{} // closed once dump and err are set
+	dump []byte
+	err  error
+}
+
+// a flightGroup coalesces concurrent calls for the same key into a single
+// execution, in the manner of golang.org/x/sync/singleflight
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*call
+}
+
+// executes fn and returns its results, making sure that only one execution is
+// in flight for a given key at a time. if a duplicate call comes in, the
+// duplicate caller waits for the original to complete (or for its own ctx to
+// be done) and receives the same results. callers must not modify the
+// returned slice.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	for {
+		g.lock.Lock()
+		if g.calls == nil {
+			g.calls = make(map[string]*call)
+		}
+		c, ok := g.calls[key]
+		if !ok {
+			break
+		}
+		g.lock.Unlock()
+
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for concurrent request for %v: %w", key, ctx.Err())
+		}
+
+		// the original caller's context ending says nothing about ours,
+		// so take over the call rather than fail with its error
+		if isContextError(c.err) && ctx.Err() == nil {
+			continue
+		}
+		return c.dump, c.err
+	}
+
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.lock.Unlock()
+
+	defer func() {
+		g.lock.Lock()
+		delete(g.calls, key)
+		g.lock.Unlock()
+		close(c.done)
+	}()
+
+	c.dump, c.err = fn(ctx)
+	return c.dump, c.err
+}
+
+// reports whether err is the result of a context being canceled or timing out
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
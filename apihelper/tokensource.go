@@ -0,0 +1,209 @@
+package apihelper
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// A Token is an API access token, along with its type and expiry.
+type Token struct {
+	AccessToken string
+	TokenType   string    // defaults to "Bearer" if empty
+	Expiry      time.Time // zero if the token never expires
+}
+
+// Type returns the token's type as used in the Authorization header,
+// defaulting to "Bearer".
+func (t *Token) Type() string {
+	if t.TokenType == "" || strings.EqualFold(t.TokenType, "bearer") {
+		return "Bearer"
+	}
+	return t.TokenType
+}
+
+// A TokenSource supplies the access tokens a CampusAPIHelper authenticates
+// its requests with. Token is called whenever the helper's current token
+// expires or is rejected, and must be safe for concurrent use.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// helper struct for unmarshalling access token regeneration responses
+type refreshTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"` // lifetime of the token in seconds
+}
+
+type clientCredentialsTokenSource struct {
+	consumerKey    string
+	consumerSecret string
+	tokenUrl       string
+	client         *http.Client
+}
+
+// ClientCredentialsTokenSource returns a TokenSource that obtains tokens from
+// tokenUrl with the OAuth2 client credentials grant, authenticating with the
+// consumer key and secret. If client is nil, http.DefaultClient is used.
+func ClientCredentialsTokenSource(consumerKey, consumerSecret, tokenUrl string, client *http.Client) TokenSource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &clientCredentialsTokenSource{
+		consumerKey:    consumerKey,
+		consumerSecret: consumerSecret,
+		tokenUrl:       tokenUrl,
+		client:         client,
+	}
+}
+
+func (ts *clientCredentialsTokenSource) Token(ctx context.Context) (_ *Token, err error) {
+	// traced with the helper's tracer, when called by a helper
+	ctx, span := tracerFrom(ctx).Start(ctx, "campusapi.tokenRequest")
+	defer func() {
+		spanError(span, err)
+		span.End()
+	}()
+
+	data := url.Values{}
+	data.Set("grant_type", "client_credentials")
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ts.tokenUrl, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(ts.consumerKey+":"+ts.consumerSecret)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if sc := span.SpanContext(); sc.IsValid() {
+		req.Header.Set("traceparent", sc.TraceParent())
+	}
+
+	response, err := ts.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	span.SetAttribute("http.status_code", response.StatusCode)
+
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %v", response.Status)
+	}
+
+	refreshResponse := &refreshTokenResponse{}
+	err = json.Unmarshal(b, refreshResponse)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if refreshResponse.AccessToken == "" {
+		return nil, fmt.Errorf("token endpoint returned no access token")
+	}
+
+	token := &Token{
+		AccessToken: refreshResponse.AccessToken,
+		TokenType:   refreshResponse.TokenType,
+	}
+	if refreshResponse.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(refreshResponse.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
+
+type staticTokenSource struct {
+	token *Token
+}
+
+// StaticTokenSource returns a TokenSource that always returns the same,
+// pre-issued token. If token is nil, Token returns an error.
+func StaticTokenSource(token *Token) TokenSource {
+	return &staticTokenSource{token: token}
+}
+
+func (ts *staticTokenSource) Token(ctx context.Context) (*Token, error) {
+	if ts.token == nil {
+		return nil, fmt.Errorf("no static token given")
+	}
+	t := *ts.token
+	return &t, nil
+}
+
+type fileTokenSource struct {
+	path string
+}
+
+// FileTokenSource returns a TokenSource that reads the token from the file
+// at path every time a token is needed, so that a sidecar or CI job can rotate
+// it in place. The file holds either the bare access token or a JSON object
+// with "access_token", "token_type" and "expiry" (RFC 3339) fields.
+func FileTokenSource(path string) TokenSource {
+	return &fileTokenSource{path: path}
+}
+
+func (ts *fileTokenSource) Token(ctx context.Context) (*Token, error) {
+	b, err := os.ReadFile(ts.path)
+	if err != nil {
+		return nil, err
+	}
+	return parseToken(b)
+}
+
+type envTokenSource struct {
+	name string
+}
+
+// EnvTokenSource returns a TokenSource that reads the token from the
+// environment variable name every time a token is needed. The variable
+// holds a token in the same formats accepted by FileTokenSource.
+func EnvTokenSource(name string) TokenSource {
+	return &envTokenSource{name: name}
+}
+
+func (ts *envTokenSource) Token(ctx context.Context) (*Token, error) {
+	value, ok := os.LookupEnv(ts.name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %v is not set", ts.name)
+	}
+	return parseToken([]byte(value))
+}
+
+// parses a token stored as either a bare access token or a JSON object
+func parseToken(b []byte) (*Token, error) {
+	b = bytes.TrimSpace(b)
+
+	token := &Token{AccessToken: string(b)}
+	if len(b) > 0 && b[0] == '{' {
+		stored := struct {
+			AccessToken string    `json:"access_token"`
+			TokenType   string    `json:"token_type"`
+			Expiry      time.Time `json:"expiry"`
+		}{}
+		err := json.Unmarshal(b, &stored)
+		if err != nil {
+			return nil, err
+		}
+		token = &Token{AccessToken: stored.AccessToken, TokenType: stored.TokenType, Expiry: stored.Expiry}
+	}
+
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("no access token found")
+	}
+
+	return token, nil
+}
@@ -0,0 +1,100 @@
+package apihelper
+
+import (
+	"context"
+	"encoding/hex"
+)
+
+// A Tracer starts spans around the work a CampusAPIHelper does, so that the
+// time a request takes can be attributed to waiting on a token refresh, a
+// cache lookup or the upstream call. It mirrors the shape of an OpenTelemetry
+// tracer, so an adapter to one is a few lines, without depending on it.
+//
+// The spans started are:
+//
+//	campusapi.Do            one per Do call, covering every attempt
+//	campusapi.roundTrip     one per request sent to the server
+//	campusapi.Get           one per Get call
+//	campusapi.cache.Get     one per cache lookup in Get
+//	campusapi.refreshAccess one per token refresh, including waiting for
+//	                        a refresh in progress on another goroutine
+//	campusapi.Token         one per token requested from the token source
+//	campusapi.tokenRequest  one per request WithClientCredentials sends to
+//	                        the token endpoint
+type Tracer interface {
+	// Start starts a span that is a child of the span in ctx, if any, and
+	// returns a context carrying the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span is a unit of work started by a Tracer
+type Span interface {
+	// SpanContext identifies the span, for propagation to the server
+	SpanContext() SpanContext
+	// SetAttribute annotates the span
+	SetAttribute(key string, value interface{})
+	// RecordError marks the span as failed
+	RecordError(err error)
+	// End finishes the span
+	End()
+}
+
+// SpanContext identifies a span across process boundaries, as in the
+// W3C Trace Context recommendation: https://www.w3.org/TR/trace-context/
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether both the trace and span IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// TraceParent returns the value of the traceparent header identifying sc
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// the Tracer used when none is configured; its spans do nothing
+type nopTracer struct{}
+
+type nopSpan struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (nopSpan) SpanContext() SpanContext                   { return SpanContext{} }
+func (nopSpan) SetAttribute(key string, value interface{}) {}
+func (nopSpan) RecordError(err error)                      {}
+func (nopSpan) End()                                       {}
+
+// the context key under which a helper passes its Tracer to its token source
+type tracerKey struct{}
+
+// returns ctx carrying tracer, for the token source to trace its requests with
+func withTracer(ctx context.Context, tracer Tracer) context.Context {
+	return context.WithValue(ctx, tracerKey{}, tracer)
+}
+
+// returns the Tracer ctx carries, or one whose spans do nothing
+func tracerFrom(ctx context.Context) Tracer {
+	if tracer, ok := ctx.Value(tracerKey{}).(Tracer); ok {
+		return tracer
+	}
+	return nopTracer{}
+}
+
+// records err on span, if there is one, and returns it
+func spanError(span Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+// Package tracetest provides an apihelper.Tracer that records spans in
+// memory, for tests that check what a CampusAPIHelper traced.
+package tracetest
+
+import (
+	"campus-api-helper/apihelper"
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+// SpanData is a snapshot of a recorded span
+type SpanData struct {
+	Name       string
+	Context    apihelper.SpanContext
+	Parent     apihelper.SpanContext // invalid for root spans
+	Attributes map[string]interface{}
+	Errors     []error
+	Start      time.Time
+	End        time.Time // zero until the span ends
+}
+
+// Recorder is an apihelper.Tracer that records every span it starts.
+// Spans are sampled, so their traceparent headers are propagated.
+type Recorder struct {
+	m     sync.Mutex
+	spans []*span
+}
+
+// NewRecorder returns a Recorder with no spans
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+type contextKey struct{}
+
+// ContextWithSpanContext returns a context in which spans started by a
+// Recorder are children of parent, as if it came from an incoming request
+func ContextWithSpanContext(ctx context.Context, parent apihelper.SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, parent)
+}
+
+// Start starts a span that is a child of the span in ctx, if any
+func (recorder *Recorder) Start(ctx context.Context, name string) (context.Context, apihelper.Span) {
+	parent, _ := ctx.Value(contextKey{}).(apihelper.SpanContext)
+
+	s := &span{data: SpanData{
+		Name:       name,
+		Parent:     parent,
+		Attributes: make(map[string]interface{}),
+		Start:      time.Now(),
+	}}
+	s.data.Context.TraceID = parent.TraceID
+	if !parent.IsValid() {
+		rand.Read(s.data.Context.TraceID[:])
+	}
+	rand.Read(s.data.Context.SpanID[:])
+	s.data.Context.Sampled = true
+
+	recorder.m.Lock()
+	recorder.spans = append(recorder.spans, s)
+	recorder.m.Unlock()
+
+	return ContextWithSpanContext(ctx, s.data.Context), s
+}
+
+// Spans returns snapshots of the spans started so far, in the order they
+// were started
+func (recorder *Recorder) Spans() []SpanData {
+	recorder.m.Lock()
+	defer recorder.m.Unlock()
+
+	spans := make([]SpanData, len(recorder.spans))
+	for i, s := range recorder.spans {
+		spans[i] = s.snapshot()
+	}
+	return spans
+}
+
+// Ended returns snapshots of the ended spans with the given name
+func (recorder *Recorder) Ended(name string) []SpanData {
+	var ended []SpanData
+	for _, data := range recorder.Spans() {
+		if data.Name == name && !data.End.IsZero() {
+			ended = append(ended, data)
+		}
+	}
+	return ended
+}
+
+// Reset discards the spans recorded so far
+func (recorder *Recorder) Reset() {
+	recorder.m.Lock()
+	defer recorder.m.Unlock()
+
+	recorder.spans = nil
+}
+
+// a span started by a Recorder
+type span struct {
+	m    sync.Mutex
+	data SpanData
+}
+
+func (s *span) SpanContext() apihelper.SpanContext {
+	return s.data.Context
+}
+
+func (s *span) SetAttribute(key string, value interface{}) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.data.Attributes[key] = value
+}
+
+func (s *span) RecordError(err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.data.Errors = append(s.data.Errors, err)
+}
+
+func (s *span) End() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.data.End.IsZero() {
+		s.data.End = time.Now()
+	}
+}
+
+func (s *span) snapshot() SpanData {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	data := s.data
+	data.Attributes = make(map[string]interface{}, len(s.data.Attributes))
+	for key, value := range s.data.Attributes {
+		data.Attributes[key] = value
+	}
+	data.Errors = append([]error(nil), s.data.Errors...)
+	return data
+}
@@ -0,0 +1,129 @@
+package tracetest
+
+import (
+	"campus-api-helper/apihelper"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// test that a Get is traced end to end, and that the round trip's span is
+// propagated to the server as a child of the caller's trace
+func TestRecorderTracesGet(t *testing.T) {
+	var traceparent string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	recorder := NewRecorder()
+	helper, err := apihelper.New(
+		apihelper.WithTokenSource(apihelper.StaticTokenSource(&apihelper.Token{AccessToken: "token"})),
+		apihelper.WithHTTPClient(server.Client()),
+		apihelper.WithTracer(recorder),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	parent := apihelper.SpanContext{TraceID: [16]byte{1}, SpanID: [8]byte{2}, Sampled: true}
+	ctx := ContextWithSpanContext(context.Background(), parent)
+
+	for i := 0; i < 2; i++ {
+		res, err := helper.GetContext(ctx, server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	gets := recorder.Ended("campusapi.Get")
+	if len(gets) != 2 {
+		t.Fatalf("got %v Get spans, want 2", len(gets))
+	}
+	if gets[0].Parent != parent || gets[0].Attributes["campusapi.cache"] != "miss" || gets[1].Attributes["campusapi.cache"] != "hit" {
+		t.Errorf("got Get spans %+v", gets)
+	}
+
+	for _, name := range []string{"campusapi.cache.Get", "campusapi.Do", "campusapi.refreshAccess", "campusapi.Token", "campusapi.roundTrip"} {
+		if len(recorder.Ended(name)) == 0 {
+			t.Errorf("no %v span was recorded", name)
+		}
+	}
+
+	roundTrips := recorder.Ended("campusapi.roundTrip")
+	if len(roundTrips) != 1 {
+		t.Fatalf("got %v round trips, want 1", len(roundTrips))
+	}
+	if want := roundTrips[0].Context.TraceParent(); traceparent != want {
+		t.Errorf("server got traceparent %q, want %q", traceparent, want)
+	}
+	if roundTrips[0].Context.TraceID != parent.TraceID {
+		t.Errorf("round trip is not part of the caller's trace")
+	}
+}
+
+// test that the token request made for WithClientCredentials is traced in a
+// span of its own under the Token span, propagated to the token endpoint, and
+// told apart from the API request's round trip
+func TestRecorderTracesTokenRequest(t *testing.T) {
+	var traceparent string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			traceparent = r.Header.Get("traceparent")
+			fmt.Fprint(w, `{"access_token":"token","token_type":"bearer","expires_in":3600}`)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	recorder := NewRecorder()
+	helper, err := apihelper.New(
+		apihelper.WithClientCredentials("key", "secret", server.URL+"/token"),
+		apihelper.WithHTTPClient(server.Client()),
+		apihelper.WithTracer(recorder),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Close()
+
+	res, err := helper.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	tokens := recorder.Ended("campusapi.Token")
+	if len(tokens) != 1 {
+		t.Fatalf("got %v Token spans, want 1", len(tokens))
+	}
+
+	tokenRequests := recorder.Ended("campusapi.tokenRequest")
+	if len(tokenRequests) != 1 {
+		t.Fatalf("got %v tokenRequest spans, want 1", len(tokenRequests))
+	}
+	tokenRequest := tokenRequests[0]
+	if tokenRequest.Parent != tokens[0].Context {
+		t.Errorf("tokenRequest span is not a child of the Token span")
+	}
+	for _, roundTrip := range recorder.Ended("campusapi.roundTrip") {
+		if roundTrip.Parent == tokens[0].Context {
+			t.Errorf("the token request was also traced as a round trip")
+		}
+	}
+	if want := tokenRequest.Context.TraceParent(); traceparent != want {
+		t.Errorf("token endpoint got traceparent %q, want %q", traceparent, want)
+	}
+	if tokenRequest.Attributes["http.status_code"] != http.StatusOK {
+		t.Errorf("got token request attributes %v", tokenRequest.Attributes)
+	}
+}
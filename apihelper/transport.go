@@ -0,0 +1,70 @@
+package apihelper
+
+import (
+	"net/http"
+)
+
+// the http.RoundTripper behind Transport and Do
+type transport struct {
+	helper *CampusAPIHelper
+	cached bool // whether GETs are answered from, and stored in, the helper's cache
+}
+
+// Transport returns an http.RoundTripper that sends requests the way the
+// helper does: with the helper's access token, refreshing it when it expires
+// or is rejected with a 401, retrying transient failures and pacing requests
+// with the rate limit, and passing them through the helper's interceptors.
+// GETs are answered from the helper's cache as described for Get, unless
+// they carry conditional or Range headers of their own.
+//
+// Any http.Client can be made to authenticate with the helper's tokens by
+// using the returned RoundTripper as its Transport, and the RoundTripper can
+// be wrapped by others, for logging or instrumentation. Requests are sent
+// upstream through the helper's own HTTP client, which is where a proxy or
+// another transport belongs.
+func (s *CampusAPIHelper) Transport() http.RoundTripper {
+	return &transport{helper: s, cached: true}
+}
+
+// Client returns an http.Client whose requests are sent through Transport
+func (s *CampusAPIHelper) Client() *http.Client {
+	return &http.Client{Transport: s.Transport()}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request it is given
+	req = req.Clone(req.Context())
+
+	err := runBefore(t.helper.interceptors, req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	var res *http.Response
+	if t.cached && isCacheableRequest(req) {
+		res, err = t.helper.get(req)
+	} else {
+		res, err = t.helper.do(req)
+	}
+
+	if res != nil && res.Request == nil {
+		res.Request = req
+	}
+	if err != nil {
+		// a RoundTripper returns either a response or an error, never both
+		if res != nil {
+			res.Body.Close()
+		}
+		return nil, err
+	}
+
+	err = runAfter(t.helper.interceptors, res)
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res, nil
+}
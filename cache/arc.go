@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// which of an ARC's four lists an entry is on
+type arcList int
+
+const (
+	arcT1 arcList = iota // resident, seen once recently
+	arcT2                // resident, seen at least twice recently
+	arcB1                // ghost, recently evicted from T1
+	arcB2                // ghost, recently evicted from T2
+)
+
+// a binding (or, on a ghost list, just the key and size) in an ARC
+type arcEntry struct {
+	key     string
+	value   []byte // nil on the ghost lists
+	size    int    // len(key) + len(value), also kept for ghosts
+	list    arcList
+	element *list.Element
+}
+
+// An ARC is a thread-safe, fixed-size in-memory cache with the Adaptive
+// Replacement Cache policy (Megiddo and Modha, 2003), adapted to count bytes
+// instead of entries. It balances a recency list T1 against a frequency list
+// T2, and uses the ghost lists B1 and B2 of recently evicted keys to learn
+// which of the two deserves more space. A one-off scan only passes through
+// T1, so it cannot flush the frequently used entries in T2.
+type ARC struct {
+	m        sync.Mutex
+	entries  map[string]*arcEntry
+	lists    [4]*list.List // front is most recently used
+	sizes    [4]int        // bytes on each list
+	target   int           // adaptive target size of T1, in bytes
+	stats    *Stats
+	capacity int
+}
+
+// NewARC returns a pointer to a new ARC with a capacity to store limit bytes
+func NewARC(limit int) *ARC {
+	arc := &ARC{
+		entries:  make(map[string]*arcEntry),
+		stats:    new(Stats),
+		capacity: limit,
+	}
+	for i := range arc.lists {
+		arc.lists[i] = list.New()
+	}
+	return arc
+}
+
+// MaxStorage returns the maximum number of bytes this ARC can store
+func (arc *ARC) MaxStorage() int {
+	arc.m.Lock()
+	defer arc.m.Unlock()
+
+	return arc.capacity
+}
+
+// RemainingStorage returns the number of unused bytes available in this ARC
+func (arc *ARC) RemainingStorage() int {
+	arc.m.Lock()
+	defer arc.m.Unlock()
+
+	return arc.capacity - arc.resident()
+}
+
+// Get returns the value associated with the given key, if it exists.
+// This operation counts as a "use" for that key-value pair
+// ok is true if a value was found and false otherwise.
+func (arc *ARC) Get(key string) (value []byte, ok bool) {
+	arc.m.Lock()
+	defer arc.m.Unlock()
+
+	entry, ok := arc.entries[key]
+	if !ok || entry.list == arcB1 || entry.list == arcB2 {
+		arc.stats.Misses++
+		return nil, false
+	}
+	arc.stats.Hits++
+	arc.move(entry, arcT2)
+	return entry.value, true
+}
+
+// Remove removes and returns the value associated with the given key, if it exists.
+// ok is true if a value was found and false otherwise
+func (arc *ARC) Remove(key string) (value []byte, ok bool) {
+	arc.m.Lock()
+	defer arc.m.Unlock()
+
+	entry, ok := arc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	arc.drop(entry)
+	if entry.list == arcB1 || entry.list == arcB2 {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Set associates the given value with the given key, possibly evicting values
+// to make room. Returns true if the binding was added successfully, else false.
+func (arc *ARC) Set(key string, value []byte) bool {
+	arc.m.Lock()
+	defer arc.m.Unlock()
+
+	size := len(key) + len(value)
+	if size > arc.capacity {
+		arc.stats.Rejected++
+		return false
+	}
+
+	entry, ok := arc.entries[key]
+	switch {
+	case ok && (entry.list == arcT1 || entry.list == arcT2):
+		// replacing a resident binding counts as a use
+		arc.sizes[entry.list] -= entry.size
+		entry.value, entry.size = value, size
+		arc.sizes[entry.list] += entry.size
+		arc.move(entry, arcT2)
+		arc.replace(entry, false)
+		return true
+
+	case ok && entry.list == arcB1:
+		// T1 was too small: grow its target
+		delta := size
+		if arc.sizes[arcB1] > 0 && arc.sizes[arcB1] < arc.sizes[arcB2] {
+			delta = size * arc.sizes[arcB2] / arc.sizes[arcB1]
+		}
+		arc.target = minInt(arc.capacity, arc.target+delta)
+		arc.drop(entry)
+		arc.replace(nil, false)
+
+	case ok && entry.list == arcB2:
+		// T2 was too small: shrink T1's target
+		delta := size
+		if arc.sizes[arcB2] > 0 && arc.sizes[arcB2] < arc.sizes[arcB1] {
+			delta = size * arc.sizes[arcB1] / arc.sizes[arcB2]
+		}
+		arc.target = maxInt(0, arc.target-delta)
+		arc.drop(entry)
+		arc.replace(nil, true)
+
+	default:
+		// a new key: keep T1 and B1 within the capacity, and all four lists
+		// within twice the capacity, by forgetting the oldest ghosts
+		for arc.sizes[arcT1]+arc.sizes[arcB1]+size > arc.capacity && arc.lists[arcB1].Len() > 0 {
+			arc.drop(arc.lists[arcB1].Back().Value.(*arcEntry))
+		}
+		for arc.total()+size > 2*arc.capacity && arc.lists[arcB2].Len() > 0 {
+			arc.drop(arc.lists[arcB2].Back().Value.(*arcEntry))
+		}
+		entry = &arcEntry{key: key, value: value, size: size, list: arcT1}
+		arc.entries[key] = entry
+		entry.element = arc.lists[arcT1].PushFront(entry)
+		arc.sizes[arcT1] += size
+		arc.replace(entry, false)
+		return true
+	}
+
+	// a ghost hit: the key returns straight to the frequency list
+	entry = &arcEntry{key: key, value: value, size: size, list: arcT2}
+	arc.entries[key] = entry
+	entry.element = arc.lists[arcT2].PushFront(entry)
+	arc.sizes[arcT2] += size
+	arc.replace(entry, false)
+	return true
+}
+
+// Len returns the number of bindings in the ARC, not counting ghosts.
+func (arc *ARC) Len() int {
+	arc.m.Lock()
+	defer arc.m.Unlock()
+
+	return arc.lists[arcT1].Len() + arc.lists[arcT2].Len()
+}
+
+// Stats returns a snapshot of the ARC's statistics. Entries does not count ghosts.
+func (arc *ARC) Stats() *Stats {
+	arc.m.Lock()
+	defer arc.m.Unlock()
+
+	return arc.stats.snapshot(arc.resident(), arc.lists[arcT1].Len()+arc.lists[arcT2].Len())
+}
+
+// evicts resident entries to the ghost lists until the resident entries fit
+// the capacity, taking from T1 while it exceeds its target and from T2
+// otherwise. never evicts protected. arc.m must be held.
+func (arc *ARC) replace(protected *arcEntry, inB2 bool) {
+	for arc.resident() > arc.capacity {
+		from := arcT2
+		t1 := arc.sizes[arcT1]
+		if arc.lists[arcT1].Len() > 0 && (t1 > arc.target || (inB2 && t1 == arc.target) || arc.lists[arcT2].Len() == 0) {
+			from = arcT1
+		}
+
+		victim := arc.lists[from].Back().Value.(*arcEntry)
+		if victim == protected {
+			if arc.lists[from].Len() == 1 {
+				from = arcT1 + arcT2 - from
+			}
+			victim = arc.lists[from].Back().Value.(*arcEntry)
+			if victim == protected {
+				victim = victim.element.Prev().Value.(*arcEntry)
+			}
+		}
+
+		arc.sizes[from] -= victim.size
+		arc.lists[from].Remove(victim.element)
+		victim.value = nil
+		victim.list = arcB1 + (from - arcT1)
+		victim.element = arc.lists[victim.list].PushFront(victim)
+		arc.sizes[victim.list] += victim.size
+		arc.stats.Evictions++
+	}
+}
+
+// moves the entry to the front of the given list. arc.m must be held.
+func (arc *ARC) move(entry *arcEntry, to arcList) {
+	arc.lists[entry.list].Remove(entry.element)
+	arc.sizes[entry.list] -= entry.size
+	entry.list = to
+	entry.element = arc.lists[to].PushFront(entry)
+	arc.sizes[to] += entry.size
+}
+
+// forgets the entry entirely. arc.m must be held.
+func (arc *ARC) drop(entry *arcEntry) {
+	arc.lists[entry.list].Remove(entry.element)
+	arc.sizes[entry.list] -= entry.size
+	delete(arc.entries, entry.key)
+}
+
+// returns the bytes held by resident entries. arc.m must be held.
+func (arc *ARC) resident() int {
+	return arc.sizes[arcT1] + arc.sizes[arcT2]
+}
+
+// returns the bytes accounted to all four lists. arc.m must be held.
+func (arc *ARC) total() int {
+	return arc.sizes[arcT1] + arc.sizes[arcT2] + arc.sizes[arcB1] + arc.sizes[arcB2]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
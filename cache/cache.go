@@ -1,8 +1,17 @@
 package cache
 
+import "time"
+
+// Stats is a snapshot of a cache's counters and occupancy
 type Stats struct {
-	Hits   int
-	Misses int
+	Hits        int
+	Misses      int
+	Evictions   int // bindings removed to make room for others
+	Expirations int // bindings removed because their TTL elapsed
+	Rejected    int // bindings not added because they exceed the capacity
+	Errors      int // lookups a server answered with an error, counted as misses
+	BytesUsed   int // bytes in use when the snapshot was taken
+	Entries     int // bindings held when the snapshot was taken
 }
 
 func (stats *Stats) Equals(other *Stats) bool {
@@ -12,7 +21,37 @@ func (stats *Stats) Equals(other *Stats) bool {
 	if stats == nil || other == nil {
 		return false
 	}
-	return stats.Hits == other.Hits && stats.Misses == other.Misses
+	return *stats == *other
+}
+
+// HitRatio returns the fraction of lookups that were hits, or 0 if there
+// were none
+func (stats *Stats) HitRatio() float64 {
+	lookups := stats.Hits + stats.Misses
+	if lookups == 0 {
+		return 0
+	}
+	return float64(stats.Hits) / float64(lookups)
+}
+
+// returns a copy of the counters, completed with the current occupancy
+func (stats *Stats) snapshot(bytesUsed, entries int) *Stats {
+	snapshot := *stats
+	snapshot.BytesUsed = bytesUsed
+	snapshot.Entries = entries
+	return &snapshot
+}
+
+// adds other's counters and occupancy to stats
+func (stats *Stats) add(other *Stats) {
+	stats.Hits += other.Hits
+	stats.Misses += other.Misses
+	stats.Evictions += other.Evictions
+	stats.Expirations += other.Expirations
+	stats.Rejected += other.Rejected
+	stats.Errors += other.Errors
+	stats.BytesUsed += other.BytesUsed
+	stats.Entries += other.Entries
 }
 
 type Cache interface {
@@ -38,7 +77,27 @@ type Cache interface {
 	// Len returns the number of bindings in the cache.
 	Len() int
 
-	// Stats returns a pointer to a Stats object that indicates how many hits
-	// and misses this cache has resolved over its lifetime.
+	// Stats returns a snapshot of the statistics this cache has gathered
+	// over its lifetime. The snapshot is not updated by later operations.
 	Stats() *Stats
 }
+
+// A TTLCache is a Cache whose bindings can expire.
+type TTLCache interface {
+	Cache
+
+	// SetWithTTL is like Set, but the binding expires after ttl.
+	// A ttl of zero means that the binding never expires.
+	SetWithTTL(key string, value []byte, ttl time.Duration) bool
+}
+
+// An ExpiryCache is a TTLCache that can tell when its bindings expire, so
+// that copies of them elsewhere (as in a Tiered) can expire along with them.
+type ExpiryCache interface {
+	TTLCache
+
+	// Expiry returns when the binding for key expires, or the zero time if
+	// it never does. It does not count as a use of the binding.
+	// ok is false if there is no such binding.
+	Expiry(key string) (expiresAt time.Time, ok bool)
+}
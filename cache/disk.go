@@ -0,0 +1,349 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// every entry file starts with this magic string, followed by the key's
+// length as a big-endian uint32, the key, and the value
+const diskMagic = "CAPIDSK1"
+
+// entry files are named after the hex SHA-256 of their key plus this suffix
+const diskSuffix = ".entry"
+
+// temporary files are written with this prefix and renamed into place
+const diskTempPrefix = ".tmp-"
+
+// metadata kept in memory for every entry on disk
+type diskEntry struct {
+	key  string
+	path string
+	size int // len(key) + len(value), as accounted by LRU
+}
+
+// A Disk is a thread-safe, fixed-size cache that persists its bindings as
+// files in a directory, with a least-recently-used eviction policy.
+//
+// Every binding is written to a temporary file that is synced and then
+// renamed into place, so a crash never leaves a partially written entry
+// behind. Recency is recorded in each file's modification time, so that
+// a new Disk opened on the same directory resumes the eviction order.
+type Disk struct {
+	m        sync.Mutex
+	dir      string
+	entries  map[string]*list.Element // values are *diskEntry
+	order    *list.List               // front is most recently used
+	stats    *Stats
+	capacity int
+	used     int
+}
+
+// NewDisk returns a pointer to a new Disk storing up to limit bytes in dir,
+// which is created if needed. Existing entries in dir are loaded, and the
+// least recently used are removed if they exceed limit.
+func NewDisk(dir string, limit int) (*Disk, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	disk := &Disk{
+		dir:      dir,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		stats:    new(Stats),
+		capacity: limit,
+	}
+
+	err = disk.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	return disk, nil
+}
+
+// loads the metadata of every entry in the directory, oldest first, and
+// cleans up leftovers of interrupted writes
+func (disk *Disk) scan() error {
+	files, err := os.ReadDir(disk.dir)
+	if err != nil {
+		return err
+	}
+
+	type found struct {
+		entry   *diskEntry
+		modTime time.Time
+	}
+	var entries []found
+
+	for _, file := range files {
+		path := filepath.Join(disk.dir, file.Name())
+		if strings.HasPrefix(file.Name(), diskTempPrefix) {
+			os.Remove(path)
+			continue
+		}
+		if file.IsDir() || !strings.HasSuffix(file.Name(), diskSuffix) {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		key, err := readDiskKey(path)
+		if err != nil || keyFile(key) != file.Name() {
+			// corrupt or foreign file
+			os.Remove(path)
+			continue
+		}
+		valueSize := int(info.Size()) - len(diskMagic) - 4 - len(key)
+		entries = append(entries, found{&diskEntry{key: key, path: path, size: len(key) + valueSize}, info.ModTime()})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	for _, e := range entries {
+		disk.entries[e.entry.key] = disk.order.PushFront(e.entry)
+		disk.used += e.entry.size
+	}
+	for disk.used > disk.capacity {
+		disk.removeElement(disk.order.Back())
+		disk.stats.Evictions++
+	}
+
+	return nil
+}
+
+// MaxStorage returns the maximum number of bytes this Disk can store
+func (disk *Disk) MaxStorage() int {
+	disk.m.Lock()
+	defer disk.m.Unlock()
+
+	return disk.capacity
+}
+
+// RemainingStorage returns the number of unused bytes available in this Disk
+func (disk *Disk) RemainingStorage() int {
+	disk.m.Lock()
+	defer disk.m.Unlock()
+
+	return disk.capacity - disk.used
+}
+
+// Get returns the value associated with the given key, if it exists.
+// This operation counts as a "use" for that key-value pair
+// ok is true if a value was found and false otherwise.
+// Entries that can no longer be read are removed and count as misses.
+func (disk *Disk) Get(key string) (value []byte, ok bool) {
+	disk.m.Lock()
+	defer disk.m.Unlock()
+
+	element, ok := disk.entries[key]
+	if ok {
+		entry := element.Value.(*diskEntry)
+		value, err := readDiskValue(entry.path, key)
+		if err == nil {
+			disk.stats.Hits++
+			disk.order.MoveToFront(element)
+			now := time.Now()
+			os.Chtimes(entry.path, now, now)
+			return value, true
+		}
+		disk.removeElement(element)
+	}
+	disk.stats.Misses++
+	return nil, false
+}
+
+// Remove removes and returns the value associated with the given key, if it exists.
+// ok is true if a value was found and false otherwise
+func (disk *Disk) Remove(key string) (value []byte, ok bool) {
+	disk.m.Lock()
+	defer disk.m.Unlock()
+
+	element, ok := disk.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*diskEntry)
+	value, err := readDiskValue(entry.path, key)
+	disk.removeElement(element)
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Set associates the given value with the given key, possibly evicting values
+// to make room. Returns true if the binding was added successfully, else false.
+// Also returns false if the binding could not be written to disk.
+func (disk *Disk) Set(key string, value []byte) bool {
+	disk.m.Lock()
+	defer disk.m.Unlock()
+
+	memory := len(key) + len(value)
+	if memory > disk.capacity {
+		disk.stats.Rejected++
+		return false
+	}
+
+	oldMemory := 0
+	if element, ok := disk.entries[key]; ok {
+		oldMemory = element.Value.(*diskEntry).size
+	}
+	// Evicting until enough memory is available. The old binding for key
+	// is replaced below, so it is neither evicted nor counted against the new one
+	for memory > disk.capacity-disk.used+oldMemory {
+		back := disk.order.Back()
+		if back.Value.(*diskEntry).key == key {
+			back = back.Prev()
+		}
+		disk.removeElement(back)
+		disk.stats.Evictions++
+	}
+
+	path := filepath.Join(disk.dir, keyFile(key))
+	err := writeDiskEntry(disk.dir, path, key, value)
+	if err != nil {
+		return false
+	}
+
+	if element, ok := disk.entries[key]; ok {
+		// the file was replaced in place by the rename
+		disk.order.Remove(element)
+		delete(disk.entries, key)
+		disk.used -= oldMemory
+	}
+
+	disk.entries[key] = disk.order.PushFront(&diskEntry{key: key, path: path, size: memory})
+	disk.used += memory
+
+	return true
+}
+
+// Len returns the number of bindings in the Disk.
+func (disk *Disk) Len() int {
+	disk.m.Lock()
+	defer disk.m.Unlock()
+
+	return len(disk.entries)
+}
+
+// Stats returns a snapshot of the Disk's statistics.
+func (disk *Disk) Stats() *Stats {
+	disk.m.Lock()
+	defer disk.m.Unlock()
+
+	return disk.stats.snapshot(disk.used, len(disk.entries))
+}
+
+// removes the element's binding and its file. disk.m must be held.
+func (disk *Disk) removeElement(element *list.Element) {
+	entry := element.Value.(*diskEntry)
+	disk.order.Remove(element)
+	delete(disk.entries, entry.key)
+	disk.used -= entry.size
+	os.Remove(entry.path)
+}
+
+// returns the name of the file that stores the binding for key
+func keyFile(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + diskSuffix
+}
+
+// atomically writes an entry file: the data is written to a temporary file
+// in the same directory, synced, and renamed over path
+func writeDiskEntry(dir, path, key string, value []byte) error {
+	tmp, err := os.CreateTemp(dir, diskTempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	header := make([]byte, len(diskMagic)+4)
+	copy(header, diskMagic)
+	binary.BigEndian.PutUint32(header[len(diskMagic):], uint32(len(key)))
+
+	_, err = tmp.Write(append(append(header, key...), value...))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	// make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+// reads the key from an entry file's header
+func readDiskKey(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(diskMagic)+4)
+	_, err = io.ReadFull(f, header)
+	if err != nil {
+		return "", err
+	}
+	if string(header[:len(diskMagic)]) != diskMagic {
+		return "", fmt.Errorf("%v is not a cache entry", path)
+	}
+
+	key := make([]byte, binary.BigEndian.Uint32(header[len(diskMagic):]))
+	_, err = io.ReadFull(f, key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(key), nil
+}
+
+// reads the value from an entry file, checking that it belongs to key
+func readDiskValue(path, key string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := len(diskMagic) + 4
+	if len(b) < prefix+len(key) || string(b[:len(diskMagic)]) != diskMagic ||
+		int(binary.BigEndian.Uint32(b[len(diskMagic):prefix])) != len(key) ||
+		!bytes.Equal(b[prefix:prefix+len(key)], []byte(key)) {
+		return nil, fmt.Errorf("%v is not the cache entry for %v", path, key)
+	}
+
+	return b[prefix+len(key):], nil
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// test that bindings survive reopening the directory, in recency order
+func TestDiskPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	disk, err := NewDisk(dir, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk.Set("a", []byte("1"))
+	time.Sleep(10 * time.Millisecond)
+	disk.Set("b", []byte("2"))
+	time.Sleep(10 * time.Millisecond)
+	disk.Get("a")
+
+	// leftovers of an interrupted write are cleaned up on startup
+	os.WriteFile(filepath.Join(dir, diskTempPrefix+"crash"), []byte("partial"), 0600)
+
+	reopened, err := NewDisk(dir, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := reopened.Get("b"); !ok || string(value) != "2" {
+		t.Errorf("got %q, %v for b, want \"2\", true", value, ok)
+	}
+	if reopened.Len() != 2 || reopened.RemainingStorage() != 2 {
+		t.Errorf("got %v bindings using %v bytes, want 2 using 4", reopened.Len(), 6-reopened.RemainingStorage())
+	}
+
+	// a was used before b, so it is evicted first
+	reopened.Set("c", []byte("333"))
+	if _, ok := reopened.Get("a"); ok {
+		t.Errorf("least recently used binding a was not evicted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, diskTempPrefix+"crash")); !os.IsNotExist(err) {
+		t.Errorf("temporary file was not removed")
+	}
+}
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// a binding in an LFU, ordered by how often and how recently it was used
+type lfuEntry struct {
+	key   string
+	value []byte
+	freq  int    // number of uses
+	tick  uint64 // logical time of the last use
+	index int    // position in the heap
+}
+
+// a min-heap of entries: least frequently used first, least recently used
+// first among equally frequent entries
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	entry := x.(*lfuEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// An LFU is a thread-safe, fixed-size in-memory cache with a
+// least-frequently-used eviction policy. Ties are broken by recency.
+// Because frequencies never decay, entries that were hot long ago can
+// linger; see TinyLFU for a policy that ages them.
+type LFU struct {
+	m        sync.Mutex
+	entries  map[string]*lfuEntry
+	order    lfuHeap
+	stats    *Stats
+	capacity int
+	used     int
+	tick     uint64
+}
+
+// NewLFU returns a pointer to a new LFU with a capacity to store limit bytes
+func NewLFU(limit int) *LFU {
+	return &LFU{
+		capacity: limit,
+		entries:  make(map[string]*lfuEntry),
+		stats:    new(Stats),
+	}
+}
+
+// MaxStorage returns the maximum number of bytes this LFU can store
+func (lfu *LFU) MaxStorage() int {
+	lfu.m.Lock()
+	defer lfu.m.Unlock()
+
+	return lfu.capacity
+}
+
+// RemainingStorage returns the number of unused bytes available in this LFU
+func (lfu *LFU) RemainingStorage() int {
+	lfu.m.Lock()
+	defer lfu.m.Unlock()
+
+	return lfu.capacity - lfu.used
+}
+
+// Get returns the value associated with the given key, if it exists.
+// This operation counts as a "use" for that key-value pair
+// ok is true if a value was found and false otherwise.
+func (lfu *LFU) Get(key string) (value []byte, ok bool) {
+	lfu.m.Lock()
+	defer lfu.m.Unlock()
+
+	entry, ok := lfu.entries[key]
+	if !ok {
+		lfu.stats.Misses++
+		return nil, false
+	}
+	lfu.stats.Hits++
+	lfu.touch(entry)
+	return entry.value, true
+}
+
+// Remove removes and returns the value associated with the given key, if it exists.
+// ok is true if a value was found and false otherwise
+func (lfu *LFU) Remove(key string) (value []byte, ok bool) {
+	lfu.m.Lock()
+	defer lfu.m.Unlock()
+
+	entry, ok := lfu.entries[key]
+	if !ok {
+		return nil, false
+	}
+	lfu.remove(entry)
+	return entry.value, true
+}
+
+// Set associates the given value with the given key, possibly evicting values
+// to make room. Returns true if the binding was added successfully, else false.
+// Replacing a binding keeps its use count.
+func (lfu *LFU) Set(key string, value []byte) bool {
+	lfu.m.Lock()
+	defer lfu.m.Unlock()
+
+	memory := len(key) + len(value)
+	if memory > lfu.capacity {
+		lfu.stats.Rejected++
+		return false
+	}
+
+	freq := 0
+	if entry, ok := lfu.entries[key]; ok {
+		freq = entry.freq
+		lfu.remove(entry)
+	}
+
+	for memory > lfu.capacity-lfu.used {
+		lfu.remove(lfu.order[0])
+		lfu.stats.Evictions++
+	}
+
+	entry := &lfuEntry{key: key, value: value, freq: freq}
+	lfu.entries[key] = entry
+	heap.Push(&lfu.order, entry)
+	lfu.touch(entry)
+	lfu.used += memory
+
+	return true
+}
+
+// Len returns the number of bindings in the LFU.
+func (lfu *LFU) Len() int {
+	lfu.m.Lock()
+	defer lfu.m.Unlock()
+
+	return len(lfu.entries)
+}
+
+// Stats returns a snapshot of the LFU's statistics.
+func (lfu *LFU) Stats() *Stats {
+	lfu.m.Lock()
+	defer lfu.m.Unlock()
+
+	return lfu.stats.snapshot(lfu.used, len(lfu.entries))
+}
+
+// records a use of the entry. lfu.m must be held.
+func (lfu *LFU) touch(entry *lfuEntry) {
+	lfu.tick++
+	entry.freq++
+	entry.tick = lfu.tick
+	heap.Fix(&lfu.order, entry.index)
+}
+
+// removes the entry's binding. lfu.m must be held.
+func (lfu *LFU) remove(entry *lfuEntry) {
+	heap.Remove(&lfu.order, entry.index)
+	delete(lfu.entries, entry.key)
+	lfu.used -= len(entry.key) + len(entry.value)
+}
@@ -2,33 +2,58 @@ package cache
 
 import (
 	"sync"
+	"time"
 )
 
+// the janitor sweeps at most this often, however short the TTLs: each sweep
+// scans every binding under the write lock, and expired bindings are already
+// treated as misses between sweeps
+const minSweepInterval = time.Second
+
 type Node struct {
-	prev  *Node
-	next  *Node
-	key   string
-	value []byte
+	prev      *Node
+	next      *Node
+	key       string
+	value     []byte
+	expiresAt time.Time // zero if the binding never expires
+}
+
+// reports whether the node's binding has expired at time now
+func (node *Node) expired(now time.Time) bool {
+	return !node.expiresAt.IsZero() && !now.Before(node.expiresAt)
 }
 
 // An LRU is a thread-sife, fixed-size in-memory cache with a least-recently-used eviction policy
 type LRU struct {
-	m        sync.RWMutex
-	entries  map[string]*Node
-	head     *Node
-	tail     *Node
-	stats    *Stats
-	capacity int
-	used     int
+	m          sync.RWMutex
+	entries    map[string]*Node
+	head       *Node
+	tail       *Node
+	stats      *Stats
+	capacity   int
+	used       int
+	defaultTTL time.Duration // applied by Set; zero if bindings never expire
+	janitor    *time.Ticker  // reclaims expired bindings; nil until one is set
+	sweep      time.Duration // the janitor's current interval
+	done       chan struct{} // closed by Close to stop the janitor
 }
 
 // NewLRU returns a pointer to a new LRU with a capacity to store limit bytes
 func NewLru(limit int) *LRU {
+	return NewLruWithTTL(limit, 0)
+}
+
+// NewLruWithTTL returns a pointer to a new LRU with a capacity to store limit
+// bytes, whose bindings added with Set expire after defaultTTL.
+// A defaultTTL of zero means that such bindings never expire.
+func NewLruWithTTL(limit int, defaultTTL time.Duration) *LRU {
 	lru := &LRU{
-		capacity: limit,
-		entries:  make(map[string]*Node),
-		stats:    new(Stats),
-		m:        sync.RWMutex{},
+		capacity:   limit,
+		entries:    make(map[string]*Node),
+		stats:      new(Stats),
+		m:          sync.RWMutex{},
+		defaultTTL: defaultTTL,
+		done:       make(chan struct{}),
 	}
 	return lru
 }
@@ -52,35 +77,23 @@ func (lru *LRU) RemainingStorage() int {
 // Get returns the value associated with the given key, if it exists.
 // This operation counts as a "use" for that key-value pair
 // ok is true if a value was found and false otherwise.
+// Expired bindings are removed and count as misses.
 func (lru *LRU) Get(key string) (value []byte, ok bool) {
 	lru.m.Lock()
 	defer lru.m.Unlock()
 
 	item, ok := lru.entries[key]
+	if ok && item.expired(time.Now()) {
+		lru.removeNode(item)
+		lru.stats.Expirations++
+		ok = false
+	}
 	if ok {
 		lru.stats.Hits++
 
 		// move node to head
-		if item == lru.head {
-			return item.value, true
-		}
-
-		if item == lru.tail {
-			lru.tail = item.next
-		}
-
-		prev := item.prev
-		if item.prev != nil {
-			item.prev.next = item.next
-		}
-		if item.next != nil {
-			item.next.prev = prev
-		}
-		if lru.head != nil {
-			lru.head.next = item
-		}
-		item.prev = lru.head
-		lru.head = item
+		lru.unlink(item)
+		lru.pushHead(item)
 
 		return item.value, true
 	}
@@ -96,36 +109,28 @@ func (lru *LRU) Remove(key string) (value []byte, ok bool) {
 
 	item, ok := lru.entries[key]
 	if ok {
-		memory := len(key) + len(item.value)
-		if item == lru.head {
-			lru.head = item.prev
-		}
-		if item == lru.tail {
-			lru.tail = item.next
-		}
-		prev := item.prev
-		if item.prev != nil {
-			item.prev.next = item.next
-		}
-		if item.next != nil {
-			item.next.prev = prev
-		}
-		value := item.value
-		delete(lru.entries, key)
-		lru.used -= memory
-		return value, true
+		lru.removeNode(item)
+		return item.value, true
 	}
 	return nil, false
 }
 
 // Set associates the given value with the given key, possibly evicting values
 // to make room. Returns true if the binding was added successfully, else false.
+// The binding expires after the LRU's default TTL, if it has one.
 func (lru *LRU) Set(key string, value []byte) bool {
+	return lru.SetWithTTL(key, value, lru.defaultTTL)
+}
+
+// SetWithTTL is like Set, but the binding expires after ttl instead of the
+// LRU's default TTL. A ttl of zero means that the binding never expires.
+func (lru *LRU) SetWithTTL(key string, value []byte, ttl time.Duration) bool {
 	lru.m.Lock()
 	defer lru.m.Unlock()
 
 	memory := len(key) + len(value)
 	if memory > lru.capacity {
+		lru.stats.Rejected++
 		return false
 	}
 	item, ok := lru.entries[key]
@@ -136,54 +141,44 @@ func (lru *LRU) Set(key string, value []byte) bool {
 		}
 
 		// Remove the old key-value pair from the cache
-		if item == lru.head {
-			lru.head = item.prev
-		}
-		if item == lru.tail {
-			lru.tail = item.next
-		}
-		prev := item.prev
-		if item.prev != nil {
-			item.prev.next = item.next
-		}
-		if item.next != nil {
-			item.next.prev = prev
-		}
-		delete(lru.entries, key)
-		lru.used -= oldMemory
+		lru.removeNode(item)
 	}
 
 	// Evicting until enough memory is available
 	for memory > lru.capacity-lru.used {
-		tail := lru.tail
-		tailMemory := len(tail.key) + len(tail.value)
-		if tail.next != nil {
-			tail.next.prev = nil
-		}
-		lru.tail = tail.next
-		delete(lru.entries, tail.key)
-		lru.used -= tailMemory
+		lru.removeNode(lru.tail)
+		lru.stats.Evictions++
 	}
 
 	// Adding new key-value pair
 	node := new(Node)
-	node.prev = lru.head
 	node.key = key
 	node.value = value
-	lru.entries[key] = node
-	if lru.head != nil {
-		lru.head.next = node
-	}
-	lru.head = node
-
-	if lru.tail == nil {
-		lru.tail = node
+	if ttl > 0 {
+		node.expiresAt = time.Now().Add(ttl)
+		lru.startJanitor(ttl)
 	}
+	lru.entries[key] = node
+	lru.pushHead(node)
 	lru.used += memory
 
 	return true
 }
 
+// Expiry returns when the binding for key expires, or the zero time if it
+// never does, without counting as a use.
+// ok is false if there is no such binding, or if it has expired.
+func (lru *LRU) Expiry(key string) (expiresAt time.Time, ok bool) {
+	lru.m.RLock()
+	defer lru.m.RUnlock()
+
+	item, ok := lru.entries[key]
+	if !ok || item.expired(time.Now()) {
+		return time.Time{}, false
+	}
+	return item.expiresAt, true
+}
+
 // Len returns the number of bindings in the LRU.
 func (lru *LRU) Len() int {
 	lru.m.RLock()
@@ -192,10 +187,135 @@ func (lru *LRU) Len() int {
 	return len(lru.entries)
 }
 
-// Stats returns statistics about how many search hits and misses have occurred.
+// Stats returns a snapshot of the LRU's statistics.
 func (lru *LRU) Stats() *Stats {
 	lru.m.RLock()
 	defer lru.m.RUnlock()
 
-	return lru.stats
+	return lru.stats.snapshot(lru.used, len(lru.entries))
+}
+
+// Close stops the LRU's background janitor. Expired bindings are still
+// treated as misses afterwards, but their bytes are only reclaimed on access.
+func (lru *LRU) Close() {
+	lru.m.Lock()
+	defer lru.m.Unlock()
+
+	select {
+	case <-lru.done:
+	default:
+		close(lru.done)
+	}
+}
+
+// starts the janitor, if it isn't running yet, so that it sweeps at least
+// once per interval, or once per minSweepInterval if interval is shorter.
+// lru.m must be held for writing.
+func (lru *LRU) startJanitor(interval time.Duration) {
+	select {
+	case <-lru.done:
+		return
+	default:
+	}
+
+	if interval < minSweepInterval {
+		interval = minSweepInterval
+	}
+
+	if lru.janitor != nil {
+		if interval < lru.sweep {
+			lru.sweep = interval
+			lru.janitor.Reset(interval)
+		}
+		return
+	}
+
+	lru.sweep = interval
+	lru.janitor = time.NewTicker(interval)
+	go func(ticker *time.Ticker, done chan struct{}) {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				lru.removeExpired()
+			case <-done:
+				return
+			}
+		}
+	}(lru.janitor, lru.done)
+}
+
+// removes all expired bindings, reclaiming their bytes
+func (lru *LRU) removeExpired() {
+	lru.m.Lock()
+	defer lru.m.Unlock()
+
+	now := time.Now()
+	for _, node := range lru.entries {
+		if node.expired(now) {
+			lru.removeNode(node)
+			lru.stats.Expirations++
+		}
+	}
+}
+
+// evicts the least recently used binding, unless it is the binding for
+// protected. returns the number of bytes freed, or 0 if nothing was evicted.
+func (lru *LRU) evictTail(protected string) int {
+	lru.m.Lock()
+	defer lru.m.Unlock()
+
+	if lru.tail == nil || lru.tail.key == protected {
+		return 0
+	}
+	memory := len(lru.tail.key) + len(lru.tail.value)
+	lru.removeNode(lru.tail)
+	lru.stats.Evictions++
+	return memory
+}
+
+// returns the number of bytes in use
+func (lru *LRU) usedStorage() int {
+	lru.m.RLock()
+	defer lru.m.RUnlock()
+
+	return lru.used
+}
+
+// removes the node's binding from the LRU. lru.m must be held for writing.
+func (lru *LRU) removeNode(node *Node) {
+	lru.unlink(node)
+	delete(lru.entries, node.key)
+	lru.used -= len(node.key) + len(node.value)
+}
+
+// detaches the node from the recency list. the list runs from the least
+// recently used node (tail) to the most recently used node (head) via next.
+func (lru *LRU) unlink(node *Node) {
+	if node == lru.head {
+		lru.head = node.prev
+	}
+	if node == lru.tail {
+		lru.tail = node.next
+	}
+	if node.prev != nil {
+		node.prev.next = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	}
+	node.prev = nil
+	node.next = nil
+}
+
+// attaches the node at the most recently used end of the recency list
+func (lru *LRU) pushHead(node *Node) {
+	node.prev = lru.head
+	if lru.head != nil {
+		lru.head.next = node
+	}
+	lru.head = node
+	if lru.tail == nil {
+		lru.tail = node
+	}
 }
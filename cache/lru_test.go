@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// test that bindings are evicted in least-recently-used order
+func TestLRUEviction(t *testing.T) {
+	lru := NewLru(6)
+
+	lru.Set("a", []byte("1"))
+	lru.Set("b", []byte("2"))
+	lru.Set("c", []byte("3"))
+	lru.Get("a")
+	lru.Set("d", []byte("4"))
+
+	if _, ok := lru.Get("b"); ok {
+		t.Errorf("least recently used binding b was not evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := lru.Get(key); !ok {
+			t.Errorf("binding %v was evicted", key)
+		}
+	}
+	if lru.RemainingStorage() != 0 || lru.Len() != 3 {
+		t.Errorf("got %v bindings using %v bytes, want 3 using 6", lru.Len(), lru.MaxStorage()-lru.RemainingStorage())
+	}
+	if lru.Stats().Evictions != 1 {
+		t.Errorf("got %v evictions, want 1", lru.Stats().Evictions)
+	}
+}
+
+// test that expired bindings are misses and that the janitor reclaims their bytes
+func TestLRUExpiration(t *testing.T) {
+	lru := NewLruWithTTL(100, 20*time.Millisecond)
+	defer lru.Close()
+
+	lru.Set("a", []byte("1"))
+	lru.SetWithTTL("b", []byte("2"), 0)
+	lru.SetWithTTL("c", []byte("3"), time.Hour)
+
+	time.Sleep(30 * time.Millisecond)
+
+	if _, ok := lru.Get("a"); ok {
+		t.Errorf("expired binding a was returned")
+	}
+	lru.SetWithTTL("d", []byte("4"), 10*time.Millisecond)
+
+	// however short the TTLs, the janitor doesn't sweep more than once per minSweepInterval
+	lru.m.RLock()
+	sweep := lru.sweep
+	lru.m.RUnlock()
+	if sweep != minSweepInterval {
+		t.Errorf("janitor sweeps every %v, want %v", sweep, minSweepInterval)
+	}
+
+	time.Sleep(minSweepInterval + 50*time.Millisecond)
+
+	if lru.Len() != 2 || lru.RemainingStorage() != 96 {
+		t.Errorf("got %v bindings using %v bytes, want 2 using 4", lru.Len(), lru.MaxStorage()-lru.RemainingStorage())
+	}
+	if stats := lru.Stats(); stats.Expirations != 2 || stats.Evictions != 0 {
+		t.Errorf("got %v expirations and %v evictions, want 2 and 0", stats.Expirations, stats.Evictions)
+	}
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"bufio"
+	"campus-api-helper/internal/promtext"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// PrometheusContentType is the content type of the text exposition format
+const PrometheusContentType = promtext.ContentType
+
+// a metric exported for every cache, read from a Stats snapshot
+type cacheMetric struct {
+	name  string
+	kind  string
+	help  string
+	value func(cache Cache, stats *Stats) float64
+}
+
+var cacheMetrics = []cacheMetric{
+	{"campus_cache_hits_total", "counter", "Lookups that found a binding.",
+		func(_ Cache, stats *Stats) float64 { return float64(stats.Hits) }},
+	{"campus_cache_misses_total", "counter", "Lookups that found no binding.",
+		func(_ Cache, stats *Stats) float64 { return float64(stats.Misses) }},
+	{"campus_cache_evictions_total", "counter", "Bindings removed to make room for others.",
+		func(_ Cache, stats *Stats) float64 { return float64(stats.Evictions) }},
+	{"campus_cache_expirations_total", "counter", "Bindings removed because their TTL elapsed.",
+		func(_ Cache, stats *Stats) float64 { return float64(stats.Expirations) }},
+	{"campus_cache_rejected_sets_total", "counter", "Bindings not added because they exceed the capacity.",
+		func(_ Cache, stats *Stats) float64 { return float64(stats.Rejected) }},
+	{"campus_cache_errors_total", "counter", "Lookups a server answered with an error.",
+		func(_ Cache, stats *Stats) float64 { return float64(stats.Errors) }},
+	{"campus_cache_bytes_used", "gauge", "Bytes currently in use.",
+		func(_ Cache, stats *Stats) float64 { return float64(stats.BytesUsed) }},
+	{"campus_cache_entries", "gauge", "Bindings currently held.",
+		func(_ Cache, stats *Stats) float64 { return float64(stats.Entries) }},
+	{"campus_cache_capacity_bytes", "gauge", "Maximum number of bytes the cache can hold.",
+		func(cache Cache, _ *Stats) float64 { return float64(cache.MaxStorage()) }},
+	{"campus_cache_hit_ratio", "gauge", "Fraction of lookups that were hits.",
+		func(_ Cache, stats *Stats) float64 { return stats.HitRatio() }},
+}
+
+// WritePrometheus writes the statistics of each named cache to w in the
+// Prometheus text exposition format, labelled by name.
+func WritePrometheus(w io.Writer, caches map[string]Cache) error {
+	names := make([]string, 0, len(caches))
+	for name := range caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// take every snapshot up front so all metrics of a cache agree
+	stats := make([]*Stats, len(names))
+	for i, name := range names {
+		stats[i] = caches[name].Stats()
+	}
+
+	out := bufio.NewWriter(w)
+	for _, metric := range cacheMetrics {
+		promtext.WriteHeader(out, metric.name, metric.kind, metric.help)
+		for i, name := range names {
+			promtext.WriteSample(out, metric.name, []string{"cache", name}, metric.value(caches[name], stats[i]))
+		}
+	}
+	return out.Flush()
+}
+
+// MetricsHandler returns an http.Handler that serves the statistics of each
+// named cache in the Prometheus text exposition format.
+func MetricsHandler(caches map[string]Cache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", PrometheusContentType)
+		WritePrometheus(w, caches)
+	})
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// test that Stats returns a snapshot that counts rejections and occupancy
+func TestStatsSnapshot(t *testing.T) {
+	lru := NewLru(6)
+
+	lru.Set("a", []byte("1"))
+	lru.Set("big", []byte("too large"))
+	lru.Get("a")
+	lru.Get("b")
+	stats := lru.Stats()
+	lru.Get("a")
+
+	want := &Stats{Hits: 1, Misses: 1, Rejected: 1, BytesUsed: 2, Entries: 1}
+	if !stats.Equals(want) {
+		t.Errorf("got %+v, want %+v", *stats, *want)
+	}
+	if ratio := stats.HitRatio(); ratio != 0.5 {
+		t.Errorf("got hit ratio %v, want 0.5", ratio)
+	}
+}
+
+// test that the metrics handler exports every cache in the text format
+func TestMetricsHandler(t *testing.T) {
+	lru := NewLru(100)
+	lru.Set("a", []byte("1"))
+	lru.Get("a")
+
+	rec := httptest.NewRecorder()
+	MetricsHandler(map[string]Cache{"http": lru, "nop": NewNop()}).
+		ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if contentType := rec.Header().Get("Content-Type"); contentType != PrometheusContentType {
+		t.Errorf("got content type %q", contentType)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE campus_cache_hits_total counter",
+		`campus_cache_hits_total{cache="http"} 1`,
+		`campus_cache_bytes_used{cache="http"} 2`,
+		`campus_cache_capacity_bytes{cache="http"} 100`,
+		`campus_cache_hit_ratio{cache="http"} 1`,
+		`campus_cache_entries{cache="nop"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
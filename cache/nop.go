@@ -0,0 +1,57 @@
+package cache
+
+import "sync"
+
+// A Nop is a Cache that stores nothing. Every Get is a miss and every Set
+// fails, which disables caching wherever a Cache is expected.
+type Nop struct {
+	m     sync.Mutex
+	stats *Stats
+}
+
+// NewNop returns a pointer to a new Nop
+func NewNop() *Nop {
+	return &Nop{stats: new(Stats)}
+}
+
+// MaxStorage returns 0, as a Nop cannot store anything
+func (nop *Nop) MaxStorage() int {
+	return 0
+}
+
+// RemainingStorage returns 0, as a Nop cannot store anything
+func (nop *Nop) RemainingStorage() int {
+	return 0
+}
+
+// Get records a miss and returns false
+func (nop *Nop) Get(key string) (value []byte, ok bool) {
+	nop.m.Lock()
+	defer nop.m.Unlock()
+
+	nop.stats.Misses++
+	return nil, false
+}
+
+// Remove returns false, as there is nothing to remove
+func (nop *Nop) Remove(key string) (value []byte, ok bool) {
+	return nil, false
+}
+
+// Set discards the binding and returns false
+func (nop *Nop) Set(key string, value []byte) bool {
+	return false
+}
+
+// Len returns 0, as a Nop holds no bindings
+func (nop *Nop) Len() int {
+	return 0
+}
+
+// Stats returns a snapshot of the Nop's statistics, which only count misses.
+func (nop *Nop) Stats() *Stats {
+	nop.m.Lock()
+	defer nop.m.Unlock()
+
+	return nop.stats.snapshot(0, 0)
+}
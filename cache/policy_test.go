@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+/******************************************************************************/
+/*                            Policy simulator                                */
+/******************************************************************************/
+
+// the size of every value in the simulated traces
+const traceValueSize = 100
+
+// returns a trace of keys in the shape of our directory traffic: lookups of
+// netids following a Zipf distribution, interrupted by scans over many
+// netids that are never looked up again (like a nightly roster sync)
+func rosterTrace(length int, seed int64) []string {
+	r := rand.New(rand.NewSource(seed))
+	zipf := rand.NewZipf(r, 1.1, 1, 5000)
+
+	trace := make([]string, 0, length)
+	scans := 0
+	for len(trace) < length {
+		if len(trace)%5000 == 4000 {
+			for i := 0; i < 1000; i++ {
+				trace = append(trace, fmt.Sprintf("roster-%v-%v", scans, i))
+			}
+			scans++
+			continue
+		}
+		trace = append(trace, fmt.Sprintf("netid-%v", zipf.Uint64()))
+	}
+	return trace[:length]
+}
+
+// replays the trace against c, setting every key that misses, and returns
+// the hit ratio
+func replay(c Cache, trace []string) float64 {
+	value := make([]byte, traceValueSize)
+	for _, key := range trace {
+		if _, ok := c.Get(key); !ok {
+			c.Set(key, value)
+		}
+	}
+	stats := c.Stats()
+	return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+}
+
+// test that the scan-resistant policies beat LRU on a trace with scans,
+// and report every policy's hit ratio
+func TestPolicySimulator(t *testing.T) {
+	trace := rosterTrace(100000, 1)
+	entries := 200
+	limit := entries * (traceValueSize + len("netid-0000"))
+
+	ratios := map[string]float64{}
+	for _, policy := range []struct {
+		name  string
+		cache Cache
+	}{
+		{"LRU", NewLru(limit)},
+		{"LFU", NewLFU(limit)},
+		{"ARC", NewARC(limit)},
+		{"W-TinyLFU", NewTinyLFU(limit, entries)},
+	} {
+		ratios[policy.name] = replay(policy.cache, trace)
+		if remaining := policy.cache.RemainingStorage(); remaining < 0 {
+			t.Errorf("%v exceeded its capacity by %v bytes", policy.name, -remaining)
+		}
+		t.Logf("%-10v hit ratio %.3f", policy.name, ratios[policy.name])
+	}
+
+	for _, name := range []string{"LFU", "ARC", "W-TinyLFU"} {
+		if ratios[name] <= ratios["LRU"] {
+			t.Errorf("%v hit ratio %.3f does not beat LRU's %.3f", name, ratios[name], ratios["LRU"])
+		}
+	}
+}
+
+// test the basic Cache contract for every policy
+func TestPolicyContract(t *testing.T) {
+	for name, c := range map[string]Cache{
+		"LFU":       NewLFU(100),
+		"ARC":       NewARC(100),
+		"W-TinyLFU": NewTinyLFU(100, 10),
+	} {
+		if !c.Set("a", []byte("1")) || !c.Set("b", []byte("2")) {
+			t.Fatalf("%v: Set failed", name)
+		}
+		if c.Set("big", make([]byte, 100)) {
+			t.Errorf("%v: Set of a binding larger than the capacity succeeded", name)
+		}
+		if value, ok := c.Get("a"); !ok || string(value) != "1" {
+			t.Errorf("%v: got %q, %v, want \"1\", true", name, value, ok)
+		}
+		if value, ok := c.Remove("b"); !ok || string(value) != "2" {
+			t.Errorf("%v: Remove got %q, %v, want \"2\", true", name, value, ok)
+		}
+		if c.Len() != 1 || c.RemainingStorage() != 98 {
+			t.Errorf("%v: got %v bindings and %v bytes remaining, want 1 and 98", name, c.Len(), c.RemainingStorage())
+		}
+	}
+}
+
+// test that ARC adapts its target without dividing by zero when a ghost hit
+// comes from a list whose bindings are all empty
+func TestARCEmptyGhosts(t *testing.T) {
+	arc := NewARC(4)
+	arc.Set("a", []byte("1"))
+	arc.Get("a")
+	arc.Set("b", []byte("1"))
+	arc.Get("b")
+	arc.Set("", nil)
+	arc.Set("c", []byte("1")) // evicts "" and c to B1
+	arc.Set("c", []byte("1")) // evicts a to B2, leaving only "" in B1
+	if arc.sizes[arcB1] != 0 || arc.sizes[arcB2] == 0 {
+		t.Fatalf("got ghost sizes %v and %v, want 0 and more", arc.sizes[arcB1], arc.sizes[arcB2])
+	}
+
+	if !arc.Set("", nil) {
+		t.Fatal("Set failed")
+	}
+	if arc.target != 0 {
+		t.Errorf("got target %v, want 0", arc.target)
+	}
+	if value, ok := arc.Get(""); !ok || len(value) != 0 {
+		t.Errorf("got %q, %v, want \"\", true", value, ok)
+	}
+}
@@ -0,0 +1,351 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how long a Redis serves from its fallback after losing the server,
+// before trying the server again
+const redisRetryInterval = 5 * time.Second
+
+// RedisConfig configures the connection of a Redis cache
+type RedisConfig struct {
+	Addr        string        // host:port of the server
+	Password    string        // sent with AUTH if not empty
+	DB          int           // selected with SELECT if not zero
+	Prefix      string        // prepended to every key, to share a server between caches
+	DefaultTTL  time.Duration // applied by Set; zero if bindings never expire
+	PoolSize    int           // idle connections kept open; defaults to 8
+	DialTimeout time.Duration // defaults to 1 second
+	IOTimeout   time.Duration // bounds every command; defaults to 1 second
+}
+
+// A Redis is a thread-safe cache stored on a server speaking the Redis
+// protocol (RESP), so that it can be shared between processes. Connections
+// are pooled. The server enforces its own memory limit and eviction policy;
+// limit only bounds the size of a single binding, and the capacity of the
+// local fallback.
+//
+// While the server is unreachable, the Redis serves from (and writes to) a
+// local LRU fallback, and retries the server every few seconds. Bindings
+// written to the fallback are not copied to the server once it returns.
+// Error replies, such as NOAUTH or WRONGTYPE, mean the server is up but
+// misconfigured, so they fail the command instead of using the fallback.
+type Redis struct {
+	config    RedisConfig
+	capacity  int
+	pool      chan *respConn
+	fallback  *LRU
+	m         sync.Mutex
+	stats     *Stats
+	downUntil time.Time // the server is not contacted before this time
+}
+
+// NewRedis returns a pointer to a new Redis using the server described by
+// config. The server is not contacted until the cache is first used.
+func NewRedis(config RedisConfig, limit int) *Redis {
+	if config.PoolSize <= 0 {
+		config.PoolSize = 8
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = time.Second
+	}
+	if config.IOTimeout <= 0 {
+		config.IOTimeout = time.Second
+	}
+
+	return &Redis{
+		config:   config,
+		capacity: limit,
+		pool:     make(chan *respConn, config.PoolSize),
+		fallback: NewLruWithTTL(limit, config.DefaultTTL),
+		stats:    new(Stats),
+	}
+}
+
+// MaxStorage returns the maximum number of bytes a single binding may use
+func (redis *Redis) MaxStorage() int {
+	return redis.capacity
+}
+
+// RemainingStorage returns the number of bytes the next binding may use.
+// The server manages its memory for every cache sharing it, and evicts by
+// its own policy, so this is the per-binding limit, or the fallback's
+// remaining storage while the server is unreachable.
+func (redis *Redis) RemainingStorage() int {
+	redis.m.Lock()
+	down := time.Now().Before(redis.downUntil)
+	redis.m.Unlock()
+	if down {
+		return redis.fallback.RemainingStorage()
+	}
+	return redis.capacity
+}
+
+// Get returns the value associated with the given key, if it exists.
+// ok is true if a value was found and false otherwise. An error reply from
+// the server, such as WRONGTYPE or NOAUTH, counts as a miss and an error.
+func (redis *Redis) Get(key string) (value []byte, ok bool) {
+	reply, err := redis.do([]byte("GET"), redis.key(key))
+	switch {
+	case err == nil:
+		value, _ = reply.([]byte)
+		ok = value != nil
+	case !isRespError(err):
+		value, ok = redis.fallback.Get(key)
+	}
+
+	redis.m.Lock()
+	defer redis.m.Unlock()
+
+	if isRespError(err) {
+		redis.stats.Errors++
+	}
+	if ok {
+		redis.stats.Hits++
+	} else {
+		redis.stats.Misses++
+	}
+	return value, ok
+}
+
+// Remove removes the binding for the given key from the server, with a
+// single DEL, and from the fallback. The server's value is not read, so the
+// value returned is the fallback's, if it held one.
+// ok is true if either held a binding and false otherwise
+func (redis *Redis) Remove(key string) (value []byte, ok bool) {
+	value, ok = redis.fallback.Remove(key)
+
+	reply, err := redis.do([]byte("DEL"), redis.key(key))
+	if err != nil {
+		return value, ok
+	}
+	if deleted, _ := reply.(int64); deleted > 0 {
+		ok = true
+	}
+	return value, ok
+}
+
+// Set associates the given value with the given key, expiring after the
+// configured default TTL. Returns true if the binding was added successfully,
+// else false.
+func (redis *Redis) Set(key string, value []byte) bool {
+	return redis.SetWithTTL(key, value, redis.config.DefaultTTL)
+}
+
+// SetWithTTL is like Set, but the binding expires after ttl instead of the
+// default TTL. A ttl of zero means that the binding never expires.
+func (redis *Redis) SetWithTTL(key string, value []byte, ttl time.Duration) bool {
+	if len(key)+len(value) > redis.capacity {
+		redis.m.Lock()
+		redis.stats.Rejected++
+		redis.m.Unlock()
+		return false
+	}
+
+	args := [][]byte{[]byte("SET"), redis.key(key), value}
+	if ttl > 0 {
+		ms := ttl.Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		args = append(args, []byte("PX"), []byte(strconv.FormatInt(ms, 10)))
+	}
+
+	_, err := redis.do(args...)
+	if isRespError(err) {
+		return false
+	}
+	if err != nil {
+		return redis.fallback.SetWithTTL(key, value, ttl)
+	}
+	return true
+}
+
+// Expiry returns when the binding for key expires, or the zero time if it
+// never does, as reported by the server's PTTL or by the fallback while the
+// server is unreachable.
+// ok is false if there is no such binding.
+func (redis *Redis) Expiry(key string) (expiresAt time.Time, ok bool) {
+	reply, err := redis.do([]byte("PTTL"), redis.key(key))
+	if isRespError(err) {
+		return time.Time{}, false
+	}
+	if err != nil {
+		return redis.fallback.Expiry(key)
+	}
+	ms, _ := reply.(int64)
+	switch {
+	case ms == -1:
+		return time.Time{}, true
+	case ms < 0:
+		return time.Time{}, false
+	}
+	return time.Now().Add(time.Duration(ms) * time.Millisecond), true
+}
+
+// Len returns the number of keys under the configured prefix on the server,
+// or the number of bindings in the fallback while the server is unreachable.
+// It SCANs the whole keyspace, so it is meant for diagnostics, not for hot
+// paths: Stats, which metrics are collected from, does not call it.
+func (redis *Redis) Len() int {
+	count := 0
+	cursor := []byte("0")
+	for {
+		reply, err := redis.do([]byte("SCAN"), cursor, []byte("MATCH"), append(escapeGlob(redis.config.Prefix), '*'), []byte("COUNT"), []byte("1000"))
+		if err != nil {
+			return redis.fallback.Len()
+		}
+		items, _ := reply.([]interface{})
+		if len(items) != 2 {
+			return redis.fallback.Len()
+		}
+		keys, _ := items[1].([]interface{})
+		count += len(keys)
+		cursor, _ = items[0].([]byte)
+		if string(cursor) == "0" || cursor == nil {
+			return count
+		}
+	}
+}
+
+// Stats returns a snapshot of the Redis's statistics. Evictions,
+// expirations and occupancy happen on the server and are not tracked.
+func (redis *Redis) Stats() *Stats {
+	redis.m.Lock()
+	defer redis.m.Unlock()
+
+	return redis.stats.snapshot(0, 0)
+}
+
+// Close closes the pooled connections and stops the fallback's janitor
+func (redis *Redis) Close() {
+	redis.fallback.Close()
+	for {
+		select {
+		case conn := <-redis.pool:
+			conn.close()
+		default:
+			return
+		}
+	}
+}
+
+// returns the server-side key for key
+func (redis *Redis) key(key string) []byte {
+	return []byte(redis.config.Prefix + key)
+}
+
+// sends a command on a pooled connection. returns an error without
+// contacting the server if it was recently found unreachable.
+func (redis *Redis) do(args ...[]byte) (interface{}, error) {
+	redis.m.Lock()
+	down := time.Now().Before(redis.downUntil)
+	redis.m.Unlock()
+	if down {
+		return nil, errRedisDown
+	}
+
+	conn, pooled := redis.idleConn()
+	if pooled {
+		reply, err := redis.send(conn, args)
+		if err == nil || isRespError(err) {
+			return reply, err
+		}
+		// the server may have closed the connection while it sat idle,
+		// which says nothing about whether the server is up: try a new one
+	}
+
+	conn, err := redis.dial()
+	if err == nil {
+		var reply interface{}
+		reply, err = redis.send(conn, args)
+		if err == nil || isRespError(err) {
+			return reply, err
+		}
+	}
+
+	redis.m.Lock()
+	redis.downUntil = time.Now().Add(redisRetryInterval)
+	redis.m.Unlock()
+
+	return nil, err
+}
+
+var errRedisDown = errors.New("redis: server unreachable")
+
+// sends a command on conn, which is returned to the pool if it is still
+// healthy afterwards, and closed otherwise
+func (redis *Redis) send(conn *respConn, args [][]byte) (interface{}, error) {
+	reply, err := conn.do(args...)
+	if err == nil || isRespError(err) {
+		redis.release(conn)
+	} else {
+		conn.close()
+	}
+	return reply, err
+}
+
+// reports whether err is an error reply, after which the connection is
+// still usable
+func isRespError(err error) bool {
+	var respErr respError
+	return errors.As(err, &respErr)
+}
+
+// takes an idle connection from the pool, if there is one
+func (redis *Redis) idleConn() (*respConn, bool) {
+	select {
+	case conn := <-redis.pool:
+		return conn, true
+	default:
+		return nil, false
+	}
+}
+
+// dials a new connection, authenticated and with the configured database selected
+func (redis *Redis) dial() (*respConn, error) {
+	conn, err := dialResp(redis.config.Addr, redis.config.DialTimeout, redis.config.IOTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if redis.config.Password != "" {
+		_, err = conn.do([]byte("AUTH"), []byte(redis.config.Password))
+	}
+	if err == nil && redis.config.DB != 0 {
+		_, err = conn.do([]byte("SELECT"), []byte(strconv.Itoa(redis.config.DB)))
+	}
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// returns a healthy connection to the pool, or closes it if the pool is full
+func (redis *Redis) release(conn *respConn) {
+	select {
+	case redis.pool <- conn:
+	default:
+		conn.close()
+	}
+}
+
+// escapes the glob metacharacters of a SCAN MATCH pattern
+func escapeGlob(s string) []byte {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.Bytes()
+}
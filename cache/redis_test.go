@@ -0,0 +1,313 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/******************************************************************************/
+/*                          In-process RESP server                            */
+/******************************************************************************/
+
+// a respServer is a minimal stand-in for a Redis server, supporting the
+// commands a Redis cache sends
+type respServer struct {
+	listener net.Listener
+	m        sync.Mutex
+	data     map[string][]byte
+	expiry   map[string]time.Time
+	conns    []net.Conn
+	commands []string // the name of every command received, in order
+}
+
+func newRespServer(t *testing.T) *respServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &respServer{listener: listener, data: map[string][]byte{}, expiry: map[string]time.Time{}}
+	go server.serve()
+	t.Cleanup(server.close)
+	return server
+}
+
+func (server *respServer) addr() string {
+	return server.listener.Addr().String()
+}
+
+func (server *respServer) close() {
+	server.listener.Close()
+}
+
+func (server *respServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		server.m.Lock()
+		server.conns = append(server.conns, conn)
+		server.m.Unlock()
+		go server.handle(conn)
+	}
+}
+
+// closes the connections accepted so far, as a server does with idle ones
+func (server *respServer) dropConnections() {
+	server.m.Lock()
+	defer server.m.Unlock()
+
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+	server.conns = nil
+}
+
+func (server *respServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		command, err := readResp(r)
+		if err != nil {
+			return
+		}
+		items, _ := command.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			b, _ := item.([]byte)
+			args[i] = string(b)
+		}
+		server.reply(w, args)
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// writes the reply to a single command
+func (server *respServer) reply(w *bufio.Writer, args []string) {
+	server.m.Lock()
+	defer server.m.Unlock()
+
+	bulk := func(b []byte) {
+		if b == nil {
+			w.WriteString("$-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(b), b)
+	}
+
+	server.commands = append(server.commands, strings.ToUpper(args[0]))
+
+	// keys under this prefix hold some other type than strings
+	if len(args) > 1 && strings.HasPrefix(args[1], "list:") {
+		w.WriteString("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+		return
+	}
+
+	// lazily expire the key the command refers to
+	if len(args) > 1 {
+		if at, ok := server.expiry[args[1]]; ok && !time.Now().Before(at) {
+			delete(server.data, args[1])
+			delete(server.expiry, args[1])
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		w.WriteString("+PONG\r\n")
+	case "GET":
+		bulk(server.data[args[1]])
+	case "SET":
+		server.data[args[1]] = []byte(args[2])
+		delete(server.expiry, args[1])
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			server.expiry[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		w.WriteString("+OK\r\n")
+	case "DEL":
+		_, ok := server.data[args[1]]
+		delete(server.data, args[1])
+		delete(server.expiry, args[1])
+		if ok {
+			w.WriteString(":1\r\n")
+		} else {
+			w.WriteString(":0\r\n")
+		}
+	case "PTTL":
+		if _, ok := server.data[args[1]]; !ok {
+			w.WriteString(":-2\r\n")
+		} else if at, ok := server.expiry[args[1]]; ok {
+			fmt.Fprintf(w, ":%d\r\n", time.Until(at).Milliseconds())
+		} else {
+			w.WriteString(":-1\r\n")
+		}
+	case "SCAN":
+		var keys []string
+		for key := range server.data {
+			if ok, _ := path.Match(args[3], key); ok {
+				keys = append(keys, key)
+			}
+		}
+		fmt.Fprintf(w, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+		for _, key := range keys {
+			bulk([]byte(key))
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+/******************************************************************************/
+/*                                  Tests                                     */
+/******************************************************************************/
+
+// test that bindings are stored on the server under the key prefix
+func TestRedisRoundTrip(t *testing.T) {
+	server := newRespServer(t)
+
+	redis := NewRedis(RedisConfig{Addr: server.addr(), Prefix: "campus:"}, 1000)
+	defer redis.Close()
+
+	if !redis.Set("liame", []byte("920000001")) {
+		t.Fatal("Set failed")
+	}
+	if value, ok := redis.Get("liame"); !ok || string(value) != "920000001" {
+		t.Errorf("got %q, %v, want \"920000001\", true", value, ok)
+	}
+	server.m.Lock()
+	_, stored := server.data["campus:liame"]
+	server.m.Unlock()
+	if !stored {
+		t.Errorf("binding was not stored under the prefix")
+	}
+	if redis.Len() != 1 || redis.RemainingStorage() != 1000 {
+		t.Errorf("got %v bindings and %v bytes remaining", redis.Len(), redis.RemainingStorage())
+	}
+	server.m.Lock()
+	server.commands = nil
+	server.m.Unlock()
+	if _, ok := redis.Remove("liame"); !ok {
+		t.Errorf("Remove found no binding")
+	}
+	server.m.Lock()
+	commands := strings.Join(server.commands, ",")
+	server.m.Unlock()
+	if commands != "DEL" {
+		t.Errorf("Remove sent %v, want a single DEL", commands)
+	}
+	if _, ok := redis.Get("liame"); ok {
+		t.Errorf("removed binding was returned")
+	}
+	if stats := redis.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("got %+v, want 1 hit and 1 miss", *stats)
+	}
+}
+
+// test that bindings expire after their TTL
+func TestRedisTTL(t *testing.T) {
+	server := newRespServer(t)
+
+	redis := NewRedis(RedisConfig{Addr: server.addr(), DefaultTTL: 20 * time.Millisecond}, 1000)
+	defer redis.Close()
+
+	redis.Set("a", []byte("1"))
+	redis.SetWithTTL("b", []byte("2"), 0)
+	time.Sleep(30 * time.Millisecond)
+
+	if _, ok := redis.Get("a"); ok {
+		t.Errorf("expired binding a was returned")
+	}
+	if _, ok := redis.Get("b"); !ok {
+		t.Errorf("binding b without a TTL expired")
+	}
+}
+
+// test that a pooled connection the server closed while idle is replaced,
+// rather than taken as a sign that the server is down
+func TestRedisStaleConnection(t *testing.T) {
+	server := newRespServer(t)
+
+	redis := NewRedis(RedisConfig{Addr: server.addr()}, 1000)
+	defer redis.Close()
+
+	redis.Set("a", []byte("1"))
+	server.dropConnections()
+
+	if value, ok := redis.Get("a"); !ok || string(value) != "1" {
+		t.Errorf("got %q, %v, want \"1\", true from the server", value, ok)
+	}
+	if !redis.Set("b", []byte("2")) {
+		t.Fatal("Set failed")
+	}
+	server.m.Lock()
+	_, stored := server.data["b"]
+	server.m.Unlock()
+	if !stored {
+		t.Errorf("binding was written to the fallback instead of the server")
+	}
+}
+
+// test that an error reply counts as a miss and an error, rather than as an
+// outage answered from the fallback
+func TestRedisErrorReply(t *testing.T) {
+	server := newRespServer(t)
+
+	redis := NewRedis(RedisConfig{Addr: server.addr()}, 1000)
+	defer redis.Close()
+
+	redis.fallback.Set("list:a", []byte("stale"))
+	if value, ok := redis.Get("list:a"); ok {
+		t.Errorf("got %q from the fallback for a key the server refused", value)
+	}
+	if redis.Set("list:a", []byte("1")) {
+		t.Errorf("Set succeeded on a key the server refused")
+	}
+	if stats := redis.Stats(); stats.Misses != 1 || stats.Errors != 1 {
+		t.Errorf("got %+v, want 1 miss and 1 error", *stats)
+	}
+
+	// the server is still up
+	if !redis.Set("b", []byte("2")) {
+		t.Fatal("Set failed")
+	}
+	server.m.Lock()
+	_, stored := server.data["b"]
+	server.m.Unlock()
+	if !stored {
+		t.Errorf("binding was written to the fallback instead of the server")
+	}
+}
+
+// test that the cache falls back to a local LRU while the server is down
+func TestRedisFallback(t *testing.T) {
+	server := newRespServer(t)
+
+	redis := NewRedis(RedisConfig{Addr: server.addr(), DialTimeout: 100 * time.Millisecond}, 1000)
+	defer redis.Close()
+
+	redis.Set("a", []byte("1"))
+	// drop the pooled connection too, so that the next command has to dial
+	server.close()
+	redis.Close()
+
+	if _, ok := redis.Get("a"); ok {
+		t.Errorf("binding stored on the unreachable server was returned")
+	}
+	if !redis.Set("b", []byte("2")) {
+		t.Fatal("Set failed while the server was down")
+	}
+	if value, ok := redis.Get("b"); !ok || string(value) != "2" {
+		t.Errorf("got %q, %v from the fallback, want \"2\", true", value, ok)
+	}
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// a respError is an error reply from a server speaking the Redis protocol
+type respError string
+
+func (e respError) Error() string {
+	return "redis: " + string(e)
+}
+
+// a respConn is a client connection speaking RESP, the Redis serialization
+// protocol. it is not safe for concurrent use.
+type respConn struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	w         *bufio.Writer
+	ioTimeout time.Duration
+}
+
+func dialResp(addr string, dialTimeout, ioTimeout time.Duration) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &respConn{
+		conn:      conn,
+		r:         bufio.NewReader(conn),
+		w:         bufio.NewWriter(conn),
+		ioTimeout: ioTimeout,
+	}, nil
+}
+
+// sends a command and returns its reply. replies are decoded as follows:
+// simple strings as string, bulk strings as []byte (nil if null), integers as
+// int64, and arrays as []interface{}. error replies are returned as respError.
+func (c *respConn) do(args ...[]byte) (interface{}, error) {
+	if c.ioTimeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.ioTimeout))
+	}
+
+	err := writeRespArray(c.w, args)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return readResp(c.r)
+}
+
+func (c *respConn) close() error {
+	return c.conn.Close()
+}
+
+// writes a command as an array of bulk strings
+func writeRespArray(w *bufio.Writer, args [][]byte) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n", len(arg))
+		w.Write(arg)
+		_, err := w.WriteString("\r\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reads a single reply (or, on the server side, a command)
+func readResp(r *bufio.Reader) (interface{}, error) {
+	line, err := readRespLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return nil, respError(line[1:])
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return []byte(nil), nil
+		}
+		b := make([]byte, n+2)
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return []interface{}(nil), nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = readResp(r)
+			if err != nil {
+				var respErr respError
+				if !errors.As(err, &respErr) {
+					return nil, err
+				}
+				items[i] = respErr
+			}
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// reads a line terminated by CRLF, without the terminator
+func readRespLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
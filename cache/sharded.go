@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// the number of shards NewSharded uses by default
+const defaultShards = 16
+
+// A Sharded is a thread-safe, fixed-size in-memory cache that spreads its
+// bindings across independent LRUs by key hash, so that concurrent Gets for
+// different keys rarely contend for the same lock.
+//
+// The shards share one byte budget: a Set that takes the total over the
+// limit evicts the least recently used binding of one shard after another
+// (round robin) until it fits. Eviction is therefore only approximately
+// least-recently-used across the whole cache, and concurrent Sets may
+// briefly exceed the budget.
+type Sharded struct {
+	shards   []*LRU
+	capacity int
+	next     uint32 // the shard to evict from next
+}
+
+// NewSharded returns a pointer to a new Sharded with a capacity to store
+// limit bytes across the given number of shards (defaultShards if not positive)
+func NewSharded(limit int, shards int) *Sharded {
+	return NewShardedWithTTL(limit, shards, 0)
+}
+
+// NewShardedWithTTL is like NewSharded, but bindings added with Set expire
+// after defaultTTL
+func NewShardedWithTTL(limit int, shards int, defaultTTL time.Duration) *Sharded {
+	if shards <= 0 {
+		shards = defaultShards
+	}
+	sharded := &Sharded{shards: make([]*LRU, shards), capacity: limit}
+	for i := range sharded.shards {
+		// every shard may grow to the whole budget; the total is enforced in Set
+		sharded.shards[i] = NewLruWithTTL(limit, defaultTTL)
+	}
+	return sharded
+}
+
+// returns the shard responsible for key
+func (sharded *Sharded) shard(key string) *LRU {
+	return sharded.shards[fnv1a(key)%uint32(len(sharded.shards))]
+}
+
+// returns the 32-bit FNV-1a hash of key. unlike hash/fnv, it doesn't allocate.
+func fnv1a(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return hash
+}
+
+// MaxStorage returns the maximum number of bytes this Sharded can store
+func (sharded *Sharded) MaxStorage() int {
+	return sharded.capacity
+}
+
+// RemainingStorage returns the number of unused bytes available in this Sharded
+func (sharded *Sharded) RemainingStorage() int {
+	return sharded.capacity - sharded.used()
+}
+
+// Get returns the value associated with the given key, if it exists.
+// This operation counts as a "use" for that key-value pair
+// ok is true if a value was found and false otherwise.
+func (sharded *Sharded) Get(key string) (value []byte, ok bool) {
+	return sharded.shard(key).Get(key)
+}
+
+// Remove removes and returns the value associated with the given key, if it exists.
+// ok is true if a value was found and false otherwise
+func (sharded *Sharded) Remove(key string) (value []byte, ok bool) {
+	return sharded.shard(key).Remove(key)
+}
+
+// Set associates the given value with the given key, possibly evicting values
+// to make room. Returns true if the binding was added successfully, else false.
+func (sharded *Sharded) Set(key string, value []byte) bool {
+	shard := sharded.shard(key)
+	return sharded.fit(key, shard.Set(key, value))
+}
+
+// SetWithTTL is like Set, but the binding expires after ttl instead of the
+// default TTL. A ttl of zero means that the binding never expires.
+func (sharded *Sharded) SetWithTTL(key string, value []byte, ttl time.Duration) bool {
+	shard := sharded.shard(key)
+	return sharded.fit(key, shard.SetWithTTL(key, value, ttl))
+}
+
+// Expiry returns when the binding for key expires, or the zero time if it
+// never does, without counting as a use.
+// ok is false if there is no such binding.
+func (sharded *Sharded) Expiry(key string) (expiresAt time.Time, ok bool) {
+	return sharded.shard(key).Expiry(key)
+}
+
+// evicts bindings round robin across the shards until the total fits the
+// budget again, sparing the binding for key that was just added
+func (sharded *Sharded) fit(key string, added bool) bool {
+	if !added {
+		return false
+	}
+
+	used := sharded.used()
+	for skipped := 0; used > sharded.capacity && skipped < len(sharded.shards); {
+		i := atomic.AddUint32(&sharded.next, 1) % uint32(len(sharded.shards))
+		freed := sharded.shards[i].evictTail(key)
+		if freed == 0 {
+			skipped++
+			continue
+		}
+		skipped = 0
+		used -= freed
+	}
+
+	return true
+}
+
+// returns the number of bytes in use across all shards
+func (sharded *Sharded) used() int {
+	used := 0
+	for _, shard := range sharded.shards {
+		used += shard.usedStorage()
+	}
+	return used
+}
+
+// Len returns the number of bindings in the Sharded.
+func (sharded *Sharded) Len() int {
+	n := 0
+	for _, shard := range sharded.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Stats returns a snapshot of the Sharded's statistics, summed across the shards.
+func (sharded *Sharded) Stats() *Stats {
+	stats := new(Stats)
+	for _, shard := range sharded.shards {
+		stats.add(shard.Stats())
+	}
+	return stats
+}
+
+// Close stops the shards' background janitors.
+func (sharded *Sharded) Close() {
+	for _, shard := range sharded.shards {
+		shard.Close()
+	}
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+)
+
+// test that the shards together stay within the shared byte budget
+func TestShardedBudget(t *testing.T) {
+	sharded := NewSharded(100, 4)
+
+	for i := 0; i < 50; i++ {
+		key := strconv.Itoa(i)
+		if !sharded.Set(key, []byte("0123456789")) {
+			t.Fatalf("Set %v failed", key)
+		}
+		if _, ok := sharded.Get(key); !ok {
+			t.Errorf("binding %v was evicted as soon as it was set", key)
+		}
+		if remaining := sharded.RemainingStorage(); remaining < 0 {
+			t.Fatalf("exceeded the budget by %v bytes", -remaining)
+		}
+	}
+
+	if sharded.Set("big", make([]byte, 101)) {
+		t.Errorf("Set of a binding larger than the budget succeeded")
+	}
+	if stats := sharded.Stats(); stats.Hits != 50 || stats.Evictions == 0 {
+		t.Errorf("got %+v, want 50 hits and some evictions", *stats)
+	}
+}
+
+/******************************************************************************/
+/*                                Benchmarks                                  */
+/******************************************************************************/
+
+// the number of keys, and the size of their values, in the Get benchmarks
+const (
+	benchmarkKeys  = 1024
+	benchmarkValue = 512
+)
+
+// fills c with benchmarkKeys bindings and returns their keys
+func fill(c Cache) []string {
+	keys := make([]string, benchmarkKeys)
+	for i := range keys {
+		keys[i] = "/users/basic?uid=" + strconv.Itoa(i)
+		c.Set(keys[i], make([]byte, benchmarkValue))
+	}
+	return keys
+}
+
+// runs parallel Gets against c, spread evenly over its keys
+func benchmarkParallelGet(b *testing.B, c Cache) {
+	keys := fill(c)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.Get(keys[i%len(keys)])
+			i += 7
+		}
+	})
+}
+
+func BenchmarkLRUParallelGet(b *testing.B) {
+	benchmarkParallelGet(b, NewLru(benchmarkKeys*(benchmarkValue+64)))
+}
+
+func BenchmarkShardedParallelGet(b *testing.B) {
+	benchmarkParallelGet(b, NewSharded(benchmarkKeys*(benchmarkValue+64), defaultShards))
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// A Tiered is a thread-safe, two-tier cache: a small, fast L1 (typically an
+// in-process LRU) in front of a larger L2 (typically a Disk or Redis).
+// Reads check L1 first and promote L2 hits into L1; writes go through to
+// both tiers. Bindings never outlive L2's copy in L1. L1 holds a subset of
+// L2, so capacity and length are L2's.
+type Tiered struct {
+	l1    Cache
+	l2    Cache
+	m     sync.Mutex
+	stats *Stats
+}
+
+// NewTiered returns a pointer to a new Tiered layering l1 in front of l2
+func NewTiered(l1 Cache, l2 Cache) *Tiered {
+	return &Tiered{l1: l1, l2: l2, stats: new(Stats)}
+}
+
+// MaxStorage returns the maximum number of bytes L2 can store
+func (tiered *Tiered) MaxStorage() int {
+	return tiered.l2.MaxStorage()
+}
+
+// RemainingStorage returns the number of unused bytes available in L2, as L2
+// reports it
+func (tiered *Tiered) RemainingStorage() int {
+	return tiered.l2.RemainingStorage()
+}
+
+// Get returns the value associated with the given key from L1 or, failing
+// that, from L2, in which case the binding is copied into L1.
+// ok is true if a value was found and false otherwise.
+func (tiered *Tiered) Get(key string) (value []byte, ok bool) {
+	value, ok = tiered.l1.Get(key)
+	if !ok {
+		value, ok = tiered.l2.Get(key)
+		if ok {
+			tiered.promote(key, value)
+		}
+	}
+
+	tiered.m.Lock()
+	defer tiered.m.Unlock()
+
+	if ok {
+		tiered.stats.Hits++
+	} else {
+		tiered.stats.Misses++
+	}
+	return value, ok
+}
+
+// Remove removes the binding for the given key from both tiers, and returns
+// its value, if it existed in either.
+// ok is true if a value was found and false otherwise
+func (tiered *Tiered) Remove(key string) (value []byte, ok bool) {
+	value1, ok1 := tiered.l1.Remove(key)
+	value2, ok2 := tiered.l2.Remove(key)
+	if ok2 {
+		return value2, true
+	}
+	return value1, ok1
+}
+
+// Set associates the given value with the given key in both tiers.
+// Returns true if L2 accepted the binding, else false.
+func (tiered *Tiered) Set(key string, value []byte) bool {
+	ok := tiered.l2.Set(key, value)
+	if ok {
+		tiered.promote(key, value)
+	} else {
+		tiered.reject()
+		// don't serve a stale binding from L1 that L2 no longer agrees with
+		tiered.l1.Remove(key)
+	}
+	return ok
+}
+
+// SetWithTTL is like Set, but the binding expires after ttl in every tier
+// that supports expiration.
+func (tiered *Tiered) SetWithTTL(key string, value []byte, ttl time.Duration) bool {
+	ok := setWithTTL(tiered.l2, key, value, ttl)
+	if ok {
+		tiered.promote(key, value)
+	} else {
+		tiered.reject()
+		tiered.l1.Remove(key)
+	}
+	return ok
+}
+
+// Expiry returns when L2's binding for key expires, so that a Tiered can
+// itself be the L2 of another. ok is false if L2 has no such binding, or
+// can't tell when its bindings expire.
+func (tiered *Tiered) Expiry(key string) (expiresAt time.Time, ok bool) {
+	if l2, canTell := tiered.l2.(ExpiryCache); canTell {
+		return l2.Expiry(key)
+	}
+	return time.Time{}, false
+}
+
+// copies a binding L2 holds into L1, expiring it no later than L2's copy.
+// if L2 can't tell when its copy expires, or L1 can't expire bindings, the
+// binding is kept out of L1 rather than outlive L2's.
+func (tiered *Tiered) promote(key string, value []byte) {
+	var expiresAt time.Time
+	switch l2 := tiered.l2.(type) {
+	case ExpiryCache:
+		var ok bool
+		expiresAt, ok = l2.Expiry(key)
+		if !ok {
+			tiered.l1.Remove(key)
+			return
+		}
+	case TTLCache:
+		tiered.l1.Remove(key)
+		return
+	}
+
+	if expiresAt.IsZero() {
+		tiered.l1.Set(key, value)
+		return
+	}
+
+	l1, canExpire := tiered.l1.(TTLCache)
+	ttl := time.Until(expiresAt)
+	if !canExpire || ttl <= 0 {
+		tiered.l1.Remove(key)
+		return
+	}
+	l1.SetWithTTL(key, value, ttl)
+}
+
+// Len returns the number of bindings in L2.
+func (tiered *Tiered) Len() int {
+	return tiered.l2.Len()
+}
+
+// Stats returns a snapshot of the statistics of the cache as a whole: hits
+// and misses across both tiers, bindings L2 rejected, and L2's evictions,
+// expirations, errors and occupancy.
+func (tiered *Tiered) Stats() *Stats {
+	l2 := tiered.l2.Stats()
+
+	tiered.m.Lock()
+	defer tiered.m.Unlock()
+
+	stats := tiered.stats.snapshot(l2.BytesUsed, l2.Entries)
+	stats.Evictions = l2.Evictions
+	stats.Expirations = l2.Expirations
+	stats.Errors = l2.Errors
+	return stats
+}
+
+// counts a binding L2 did not accept
+func (tiered *Tiered) reject() {
+	tiered.m.Lock()
+	defer tiered.m.Unlock()
+
+	tiered.stats.Rejected++
+}
+
+// L1Stats returns L1's own statistics
+func (tiered *Tiered) L1Stats() *Stats {
+	return tiered.l1.Stats()
+}
+
+// L2Stats returns L2's own statistics. L2 is only consulted on L1 misses.
+func (tiered *Tiered) L2Stats() *Stats {
+	return tiered.l2.Stats()
+}
+
+// sets a binding with a TTL if the cache supports expiration, or without one
+// otherwise
+func setWithTTL(c Cache, key string, value []byte, ttl time.Duration) bool {
+	if ttlCache, ok := c.(TTLCache); ok {
+		return ttlCache.SetWithTTL(key, value, ttl)
+	}
+	return c.Set(key, value)
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// test that L2 hits are promoted into L1 and that stats are kept per tier
+func TestTieredPromotion(t *testing.T) {
+	l1 := NewLru(4)
+	l2 := NewLru(100)
+	tiered := NewTiered(l1, l2)
+
+	tiered.Set("a", []byte("1"))
+	tiered.Set("b", []byte("2"))
+	tiered.Set("c", []byte("3"))
+
+	// a was evicted from the small L1, but is still in L2
+	if _, ok := l1.Get("a"); ok {
+		t.Fatal("a was not evicted from L1")
+	}
+	if value, ok := tiered.Get("a"); !ok || string(value) != "1" {
+		t.Errorf("got %q, %v, want \"1\", true", value, ok)
+	}
+	if _, ok := l1.Get("a"); !ok {
+		t.Errorf("a was not promoted into L1")
+	}
+	if _, ok := tiered.Get("z"); ok {
+		t.Errorf("got a value for missing key z")
+	}
+
+	if stats := tiered.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("got %+v overall, want 1 hit and 1 miss", *stats)
+	}
+	if stats := tiered.L2Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("got %+v for L2, want 1 hit and 1 miss", *stats)
+	}
+}
+
+// test that a binding promoted into L1 expires along with L2's copy, also
+// when L2 is itself a Tiered
+func TestTieredPromotionKeepsTTL(t *testing.T) {
+	ttl := 20 * time.Millisecond
+	for _, l2 := range []TTLCache{
+		NewLruWithTTL(100, ttl),
+		NewRedis(RedisConfig{Addr: newRespServer(t).addr(), DefaultTTL: ttl}, 100),
+		NewTiered(NewLru(100), NewLruWithTTL(100, ttl)),
+	} {
+		l1 := NewLru(100)
+		tiered := NewTiered(l1, l2)
+
+		l2.SetWithTTL("k", []byte("v"), ttl)
+		if value, ok := tiered.Get("k"); !ok || string(value) != "v" {
+			t.Fatalf("%T: got %q, %v, want \"v\", true", l2, value, ok)
+		}
+		if l1.Len() != 1 {
+			t.Errorf("%T: L2's binding was not promoted into L1", l2)
+		}
+		tiered.SetWithTTL("j", []byte("w"), ttl)
+		tiered.Set("s", []byte("x"))
+
+		time.Sleep(50 * time.Millisecond)
+
+		for _, key := range []string{"k", "j", "s"} {
+			if value, ok := tiered.Get(key); ok {
+				t.Errorf("%T: got %q for %v after L2's copy expired", l2, value, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// the share of a TinyLFU's capacity given to its admission window, in percent
+const tinyLFUWindowPercent = 1
+
+// the share of the main space given to the protected segment, in percent
+const tinyLFUProtectedPercent = 80
+
+// which segment of a TinyLFU an entry is in
+type tinyLFUSegment int
+
+const (
+	segmentWindow tinyLFUSegment = iota
+	segmentProbation
+	segmentProtected
+)
+
+// a binding in a TinyLFU
+type tinyLFUEntry struct {
+	key     string
+	value   []byte
+	size    int
+	segment tinyLFUSegment
+	element *list.Element
+}
+
+// A TinyLFU is a thread-safe, fixed-size in-memory cache with the W-TinyLFU
+// policy (Einziger, Friedman and Manes, 2017), counting bytes instead of
+// entries. New bindings enter a small LRU window. A binding leaving the
+// window is only admitted to the main space, a segmented LRU, if a
+// frequency sketch of recent accesses rates it above the binding it would
+// displace. Rarely used keys, such as those of a one-off scan, are therefore
+// turned away at the door instead of flushing frequently used ones. The
+// sketch is periodically halved, so that old popularity fades.
+type TinyLFU struct {
+	m        sync.Mutex
+	entries  map[string]*tinyLFUEntry
+	segments [3]*list.List // front is most recently used
+	sizes    [3]int        // bytes in each segment
+	limits   [3]int        // capacity of each segment, in bytes
+	sketch   *countMinSketch
+	stats    *Stats
+	capacity int
+}
+
+// NewTinyLFU returns a pointer to a new TinyLFU with a capacity to store
+// limit bytes. expectedEntries sizes the frequency sketch; it should roughly
+// match the number of bindings the cache will hold.
+func NewTinyLFU(limit int, expectedEntries int) *TinyLFU {
+	window := limit * tinyLFUWindowPercent / 100
+	main := limit - window
+	protected := main * tinyLFUProtectedPercent / 100
+
+	tinyLFU := &TinyLFU{
+		entries:  make(map[string]*tinyLFUEntry),
+		limits:   [3]int{window, main - protected, protected},
+		sketch:   newCountMinSketch(expectedEntries),
+		stats:    new(Stats),
+		capacity: limit,
+	}
+	for i := range tinyLFU.segments {
+		tinyLFU.segments[i] = list.New()
+	}
+	return tinyLFU
+}
+
+// MaxStorage returns the maximum number of bytes this TinyLFU can store
+func (tinyLFU *TinyLFU) MaxStorage() int {
+	tinyLFU.m.Lock()
+	defer tinyLFU.m.Unlock()
+
+	return tinyLFU.capacity
+}
+
+// RemainingStorage returns the number of unused bytes available in this TinyLFU
+func (tinyLFU *TinyLFU) RemainingStorage() int {
+	tinyLFU.m.Lock()
+	defer tinyLFU.m.Unlock()
+
+	return tinyLFU.capacity - tinyLFU.used()
+}
+
+// Get returns the value associated with the given key, if it exists.
+// This operation counts as a "use" for that key-value pair, and every Get,
+// hit or miss, counts towards the key's frequency.
+// ok is true if a value was found and false otherwise.
+func (tinyLFU *TinyLFU) Get(key string) (value []byte, ok bool) {
+	tinyLFU.m.Lock()
+	defer tinyLFU.m.Unlock()
+
+	tinyLFU.sketch.increment(key)
+
+	entry, ok := tinyLFU.entries[key]
+	if !ok {
+		tinyLFU.stats.Misses++
+		return nil, false
+	}
+	tinyLFU.stats.Hits++
+
+	switch entry.segment {
+	case segmentWindow, segmentProtected:
+		tinyLFU.segments[entry.segment].MoveToFront(entry.element)
+	case segmentProbation:
+		// a second use in the main space earns protection
+		tinyLFU.move(entry, segmentProtected)
+		tinyLFU.demoteProtected()
+	}
+
+	return entry.value, true
+}
+
+// Remove removes and returns the value associated with the given key, if it exists.
+// ok is true if a value was found and false otherwise
+func (tinyLFU *TinyLFU) Remove(key string) (value []byte, ok bool) {
+	tinyLFU.m.Lock()
+	defer tinyLFU.m.Unlock()
+
+	entry, ok := tinyLFU.entries[key]
+	if !ok {
+		return nil, false
+	}
+	tinyLFU.remove(entry)
+	return entry.value, true
+}
+
+// Set associates the given value with the given key in the admission window.
+// Bindings pushed out of the window may evict others, or be evicted
+// themselves, depending on their frequency. Returns true if the binding was
+// added to the window successfully, else false.
+func (tinyLFU *TinyLFU) Set(key string, value []byte) bool {
+	tinyLFU.m.Lock()
+	defer tinyLFU.m.Unlock()
+
+	size := len(key) + len(value)
+	if size > tinyLFU.capacity {
+		tinyLFU.stats.Rejected++
+		return false
+	}
+
+	if entry, ok := tinyLFU.entries[key]; ok {
+		tinyLFU.remove(entry)
+	}
+
+	entry := &tinyLFUEntry{key: key, value: value, size: size, segment: segmentWindow}
+	tinyLFU.entries[key] = entry
+	entry.element = tinyLFU.segments[segmentWindow].PushFront(entry)
+	tinyLFU.sizes[segmentWindow] += size
+
+	// move the window's overflow, oldest first, to the door of the main space
+	for tinyLFU.sizes[segmentWindow] > tinyLFU.limits[segmentWindow] && tinyLFU.segments[segmentWindow].Len() > 1 {
+		candidate := tinyLFU.segments[segmentWindow].Back().Value.(*tinyLFUEntry)
+		tinyLFU.admit(candidate)
+	}
+	// a binding larger than the window may still push the total over
+	for tinyLFU.used() > tinyLFU.capacity {
+		tinyLFU.evict(tinyLFU.victim(entry))
+	}
+
+	return true
+}
+
+// Len returns the number of bindings in the TinyLFU.
+func (tinyLFU *TinyLFU) Len() int {
+	tinyLFU.m.Lock()
+	defer tinyLFU.m.Unlock()
+
+	return len(tinyLFU.entries)
+}
+
+// Stats returns a snapshot of the TinyLFU's statistics. Evictions include
+// bindings the admission policy turned away.
+func (tinyLFU *TinyLFU) Stats() *Stats {
+	tinyLFU.m.Lock()
+	defer tinyLFU.m.Unlock()
+
+	return tinyLFU.stats.snapshot(tinyLFU.used(), len(tinyLFU.entries))
+}
+
+// moves a candidate from the window to probation if it fits, or if the sketch
+// rates it above the main space's victims; evicts it otherwise.
+// tinyLFU.m must be held.
+func (tinyLFU *TinyLFU) admit(candidate *tinyLFUEntry) {
+	mainLimit := tinyLFU.limits[segmentProbation] + tinyLFU.limits[segmentProtected]
+	mainUsed := tinyLFU.sizes[segmentProbation] + tinyLFU.sizes[segmentProtected]
+
+	if mainUsed+candidate.size > mainLimit {
+		// collect victims, least valuable first, until the candidate would fit
+		candidateFreq := tinyLFU.sketch.estimate(candidate.key)
+		var victims []*tinyLFUEntry
+		freed := 0
+		for _, segment := range []tinyLFUSegment{segmentProbation, segmentProtected} {
+			for e := tinyLFU.segments[segment].Back(); e != nil && mainUsed-freed+candidate.size > mainLimit; e = e.Prev() {
+				victim := e.Value.(*tinyLFUEntry)
+				if tinyLFU.sketch.estimate(victim.key) >= candidateFreq {
+					tinyLFU.evict(candidate)
+					return
+				}
+				victims = append(victims, victim)
+				freed += victim.size
+			}
+		}
+		for _, victim := range victims {
+			tinyLFU.evict(victim)
+		}
+	}
+
+	tinyLFU.move(candidate, segmentProbation)
+}
+
+// moves the protected segment's overflow back to probation.
+// tinyLFU.m must be held.
+func (tinyLFU *TinyLFU) demoteProtected() {
+	for tinyLFU.sizes[segmentProtected] > tinyLFU.limits[segmentProtected] && tinyLFU.segments[segmentProtected].Len() > 1 {
+		tinyLFU.move(tinyLFU.segments[segmentProtected].Back().Value.(*tinyLFUEntry), segmentProbation)
+	}
+}
+
+// returns the least valuable binding other than protected.
+// tinyLFU.m must be held.
+func (tinyLFU *TinyLFU) victim(protected *tinyLFUEntry) *tinyLFUEntry {
+	for _, segment := range []tinyLFUSegment{segmentProbation, segmentProtected, segmentWindow} {
+		for e := tinyLFU.segments[segment].Back(); e != nil; e = e.Prev() {
+			if entry := e.Value.(*tinyLFUEntry); entry != protected {
+				return entry
+			}
+		}
+	}
+	return nil
+}
+
+// moves the entry to the front of the given segment. tinyLFU.m must be held.
+func (tinyLFU *TinyLFU) move(entry *tinyLFUEntry, to tinyLFUSegment) {
+	tinyLFU.segments[entry.segment].Remove(entry.element)
+	tinyLFU.sizes[entry.segment] -= entry.size
+	entry.segment = to
+	entry.element = tinyLFU.segments[to].PushFront(entry)
+	tinyLFU.sizes[to] += entry.size
+}
+
+// evicts the entry's binding. tinyLFU.m must be held.
+func (tinyLFU *TinyLFU) evict(entry *tinyLFUEntry) {
+	tinyLFU.remove(entry)
+	tinyLFU.stats.Evictions++
+}
+
+// removes the entry's binding. tinyLFU.m must be held.
+func (tinyLFU *TinyLFU) remove(entry *tinyLFUEntry) {
+	tinyLFU.segments[entry.segment].Remove(entry.element)
+	tinyLFU.sizes[entry.segment] -= entry.size
+	delete(tinyLFU.entries, entry.key)
+}
+
+// returns the bytes in use across the segments. tinyLFU.m must be held.
+func (tinyLFU *TinyLFU) used() int {
+	return tinyLFU.sizes[segmentWindow] + tinyLFU.sizes[segmentProbation] + tinyLFU.sizes[segmentProtected]
+}
+
+// the number of rows (independent hash functions) in a countMinSketch
+const sketchDepth = 4
+
+// a countMinSketch estimates how often keys were seen, in bounded space.
+// counters saturate at 15, and all are halved after a sample of
+// 10 * width increments, so that estimates reflect recent history.
+type countMinSketch struct {
+	rows      [sketchDepth][]uint8
+	mask      uint32
+	additions int
+	sample    int
+}
+
+func newCountMinSketch(expectedEntries int) *countMinSketch {
+	width := 16
+	for width < expectedEntries {
+		width *= 2
+	}
+	sketch := &countMinSketch{mask: uint32(width - 1), sample: 10 * width}
+	for i := range sketch.rows {
+		sketch.rows[i] = make([]uint8, width)
+	}
+	return sketch
+}
+
+// returns the key's counter index in each row
+func (sketch *countMinSketch) indexes(key string) [sketchDepth]uint32 {
+	// FNV-1a, then derive the other rows by double hashing
+	hash := fnv1a(key)
+	step := (hash >> 16) | 1
+	var indexes [sketchDepth]uint32
+	for i := range indexes {
+		indexes[i] = (hash + uint32(i)*step) & sketch.mask
+	}
+	return indexes
+}
+
+func (sketch *countMinSketch) increment(key string) {
+	for i, index := range sketch.indexes(key) {
+		if sketch.rows[i][index] < 15 {
+			sketch.rows[i][index]++
+		}
+	}
+	sketch.additions++
+	if sketch.additions >= sketch.sample {
+		for _, row := range sketch.rows {
+			for i := range row {
+				row[i] /= 2
+			}
+		}
+		sketch.additions /= 2
+	}
+}
+
+func (sketch *countMinSketch) estimate(key string) uint8 {
+	lowest := uint8(15)
+	for i, index := range sketch.indexes(key) {
+		if sketch.rows[i][index] < lowest {
+			lowest = sketch.rows[i][index]
+		}
+	}
+	return lowest
+}
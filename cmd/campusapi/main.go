@@ -0,0 +1,380 @@
+// Command campusapi queries Princeton's campus APIs from the command line.
+//
+// Usage:
+//
+//	campusapi [flags] get <path or url>...   issue GETs and print the responses
+//	campusapi [flags] user <netid>...        look up users in Active Directory
+//	campusapi [flags] token                  fetch and print an access token
+//	campusapi [flags] cache-stats <path>...  issue GETs and print only cache statistics
+//
+// When get, user or cache-stats are given no arguments (or "-"), they read one
+// path or netid per line from stdin. Credentials are read from flags, then from
+// the environment, then from the file named by -env (.env.local by default).
+//
+// Responses are cached in memory for the duration of a command, or on disk
+// across runs if -cache-dir (or $CAMPUSAPI_CACHE_DIR) names a directory, in
+// which case cache-stats reports the hits earned by earlier commands. Cached
+// directory responses hold personal data, so the disk cache is opt-in.
+package main
+
+import (
+	"bufio"
+	"campus-api-helper/activedirectory"
+	"campus-api-helper/apihelper"
+	"campus-api-helper/cache"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	REFRESH_TOKEN_URL = "https://api.princeton.edu:443/token"
+	CACHE_SIZE        = 10000000
+)
+
+// returned by parseConfig when the command line names no known command
+var errUsage = errors.New("no command given")
+
+// command-line configuration, after merging flags, environment and env file
+type config struct {
+	command        string
+	args           []string // the command's arguments
+	consumerKey    string
+	consumerSecret string
+	tokenUrl       string
+	tokenFile      string
+	baseUrl        string
+	cacheDir       string // responses are cached in memory only if empty
+	format         string
+	timeout        time.Duration
+	verbose        bool
+}
+
+func usage(fs *flag.FlagSet) {
+	fmt.Fprintf(fs.Output(), `usage: campusapi [flags] <command> [args]
+
+commands:
+  get <path or url>...   issue GETs and print the responses
+  user <netid>...        look up users in Active Directory
+  token                  fetch and print an access token
+  cache-stats <path>...  issue GETs and print only cache statistics
+
+get, user and cache-stats read one argument per line from stdin when given none.
+Responses are cached across runs only in -cache-dir, if set.
+
+flags:
+`)
+	fs.PrintDefaults()
+}
+
+func main() {
+	cfg, err := parseConfig(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+
+	switch cfg.command {
+	case "get":
+		err = runGet(ctx, cfg, os.Stdin, os.Stdout)
+	case "user":
+		err = runUser(ctx, cfg, os.Stdin, os.Stdout, os.Stderr)
+	case "token":
+		err = runToken(ctx, cfg, os.Stdout)
+	case "cache-stats":
+		err = runCacheStats(ctx, cfg, os.Stdin, os.Stdout)
+	}
+
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// parses the command line, without the program name, into a configuration,
+// looking up unset flags with getenv, then in the env file. errors are
+// reported to stderr, along with the usage where it helps.
+func parseConfig(args []string, getenv func(string) string, stderr io.Writer) (*config, error) {
+	fs := flag.NewFlagSet("campusapi", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	envFile := fs.String("env", ".env.local", "file to load environment variables from")
+	key := fs.String("key", "", "consumer key (default $CONSUMER_KEY)")
+	secret := fs.String("secret", "", "consumer secret (default $CONSUMER_SECRET)")
+	tokenUrl := fs.String("token-url", "", "token endpoint (default $CAMPUSAPI_TOKEN_URL or "+REFRESH_TOKEN_URL+")")
+	tokenFile := fs.String("token-file", "", "read a pre-issued access token from this file instead of using the consumer key")
+	baseUrl := fs.String("base-url", "", "base URL for relative paths (default $CAMPUSAPI_BASE_URL or the Active Directory API)")
+	cacheDir := fs.String("cache-dir", "", "directory to cache responses in across runs (default $CAMPUSAPI_CACHE_DIR, or none)")
+	format := fs.String("format", "json", "output format: json, table or csv")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout for the whole command")
+	verbose := fs.Bool("v", false, "log requests, cache lookups and token refreshes to stderr")
+	fs.Usage = func() { usage(fs) }
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	switch fs.Arg(0) {
+	case "get", "user", "token", "cache-stats":
+	case "":
+		fs.Usage()
+		return nil, errUsage
+	default:
+		err = fmt.Errorf("unknown command %q", fs.Arg(0))
+		fmt.Fprintf(stderr, "campusapi: %v\n", err)
+		fs.Usage()
+		return nil, err
+	}
+
+	// the env file never overrides variables that are already set, and is
+	// read without changing the process environment
+	fileEnv, _ := godotenv.Read(*envFile)
+	lookup := func(name string) string {
+		return firstNonEmpty(getenv(name), fileEnv[name])
+	}
+
+	cfg := &config{
+		command:        fs.Arg(0),
+		args:           fs.Args()[1:],
+		consumerKey:    firstNonEmpty(*key, lookup("CONSUMER_KEY")),
+		consumerSecret: firstNonEmpty(*secret, lookup("CONSUMER_SECRET")),
+		tokenUrl:       firstNonEmpty(*tokenUrl, lookup("CAMPUSAPI_TOKEN_URL"), REFRESH_TOKEN_URL),
+		tokenFile:      *tokenFile,
+		baseUrl:        firstNonEmpty(*baseUrl, lookup("CAMPUSAPI_BASE_URL"), activedirectory.VersionURL(activedirectory.DefaultVersion)),
+		cacheDir:       firstNonEmpty(*cacheDir, lookup("CAMPUSAPI_CACHE_DIR")),
+		format:         *format,
+		timeout:        *timeout,
+		verbose:        *verbose,
+	}
+
+	switch cfg.format {
+	case "json", "table", "csv":
+	default:
+		err = fmt.Errorf("unknown format %q", cfg.format)
+		fmt.Fprintf(stderr, "campusapi: %v\n", err)
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+/******************************************************************************/
+/*                                Commands                                    */
+/******************************************************************************/
+
+// issues a GET for every path and prints the decoded responses
+func runGet(ctx context.Context, cfg *config, stdin io.Reader, w io.Writer) error {
+	helper, err := newHelper(cfg)
+	if err != nil {
+		return err
+	}
+	defer helper.Close()
+
+	paths, err := batch(cfg.args, stdin)
+	if err != nil {
+		return err
+	}
+
+	var records []interface{}
+	for _, path := range paths {
+		record, err := get(ctx, helper, path)
+		if err != nil {
+			return err
+		}
+		// flatten arrays, so that batches of list endpoints form a single table
+		if list, ok := record.([]interface{}); ok {
+			records = append(records, list...)
+		} else {
+			records = append(records, record)
+		}
+	}
+
+	return writeRecords(w, cfg.format, records, nil)
+}
+
+// looks up every netid in Active Directory and prints the users
+func runUser(ctx context.Context, cfg *config, stdin io.Reader, w io.Writer, stderr io.Writer) error {
+	helper, err := newHelper(cfg)
+	if err != nil {
+		return err
+	}
+	defer helper.Close()
+
+	uids, err := batch(cfg.args, stdin)
+	if err != nil {
+		return err
+	}
+
+	users, err := activedirectory.NewClient(helper, cfg.baseUrl).GetUsersBasic(ctx, uids)
+	if err != nil {
+		return err
+	}
+	if len(users) < len(uids) {
+		fmt.Fprintf(stderr, "campusapi: %v of %v netids not found\n", len(uids)-len(users), len(uids))
+	}
+
+	records := make([]interface{}, len(users))
+	for i, user := range users {
+		records[i] = user
+	}
+
+	return writeRecords(w, cfg.format, records, []string{"uid", "universityid", "displayname", "mail", "department", "pustatus"})
+}
+
+// fetches an access token and prints it along with its expiry
+func runToken(ctx context.Context, cfg *config, w io.Writer) error {
+	token, err := tokenSource(cfg).Token(ctx)
+	if err != nil {
+		return fmt.Errorf("error obtaining access token: %w", err)
+	}
+
+	record := map[string]interface{}{
+		"access_token": token.AccessToken,
+		"token_type":   token.Type(),
+	}
+	if !token.Expiry.IsZero() {
+		record["expiry"] = token.Expiry.Format(time.RFC3339)
+	}
+
+	return writeRecords(w, cfg.format, []interface{}{record}, []string{"access_token", "token_type", "expiry"})
+}
+
+// issues a GET for every path, discarding the responses, and prints the
+// resulting cache statistics. hits count responses cached by earlier runs.
+func runCacheStats(ctx context.Context, cfg *config, stdin io.Reader, w io.Writer) error {
+	if cfg.cacheDir == "" {
+		return fmt.Errorf("no cache configured: set -cache-dir or CAMPUSAPI_CACHE_DIR")
+	}
+
+	helper, err := newHelper(cfg)
+	if err != nil {
+		return err
+	}
+	defer helper.Close()
+
+	paths, err := batch(cfg.args, stdin)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		_, err := get(ctx, helper, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeRecords(w, cfg.format, []interface{}{*helper.Stats()}, nil)
+}
+
+/******************************************************************************/
+/*                                 Helpers                                    */
+/******************************************************************************/
+
+// returns the token source selected by the configuration
+func tokenSource(cfg *config) apihelper.TokenSource {
+	if cfg.tokenFile != "" {
+		return apihelper.FileTokenSource(cfg.tokenFile)
+	}
+	return apihelper.ClientCredentialsTokenSource(cfg.consumerKey, cfg.consumerSecret, cfg.tokenUrl, nil)
+}
+
+// returns a CampusAPIHelper authenticated according to the configuration
+func newHelper(cfg *config) (*apihelper.CampusAPIHelper, error) {
+	if cfg.tokenFile == "" && (cfg.consumerKey == "" || cfg.consumerSecret == "") {
+		return nil, fmt.Errorf("no credentials: set CONSUMER_KEY and CONSUMER_SECRET, or use -token-file")
+	}
+	level := apihelper.LevelWarn
+	if cfg.verbose {
+		level = apihelper.LevelDebug
+	}
+	opts := []apihelper.Option{
+		apihelper.WithTokenSource(tokenSource(cfg)),
+		apihelper.WithBaseURL(cfg.baseUrl),
+		apihelper.WithCacheSize(CACHE_SIZE),
+		apihelper.WithUserAgent("campusapi"),
+		apihelper.WithLogger(apihelper.NewTextLogger(os.Stderr, level)),
+	}
+	if cfg.cacheDir != "" {
+		disk, err := cache.NewDisk(cfg.cacheDir, CACHE_SIZE)
+		if err != nil {
+			return nil, fmt.Errorf("error opening cache: %w", err)
+		}
+		opts = append(opts, apihelper.WithCache(disk))
+	}
+	return apihelper.New(opts...)
+}
+
+// issues a GET for path, relative to the base URL unless it is absolute,
+// and decodes the JSON response
+func get(ctx context.Context, helper *apihelper.CampusAPIHelper, path string) (interface{}, error) {
+	res, err := helper.GetContext(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v: %v: %s", path, res.Status, strings.TrimSpace(string(body)))
+	}
+
+	var record interface{}
+	err = json.Unmarshal(body, &record)
+	if err != nil {
+		// not JSON; pass the body through as a string
+		return string(body), nil
+	}
+
+	return record, nil
+}
+
+// returns the command's arguments, or the lines of stdin if there are none,
+// skipping blank lines and # comments
+func batch(args []string, stdin io.Reader) ([]string, error) {
+	if len(args) > 0 && !(len(args) == 1 && args[0] == "-") {
+		return args, nil
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "campusapi: "+format+"\n", args...)
+	os.Exit(1)
+}
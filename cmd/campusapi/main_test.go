@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"campus-api-helper/activedirectory"
+	"campus-api-helper/apihelper/apihelpertest"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+/******************************************************************************/
+/*                                  Tests                                     */
+/******************************************************************************/
+
+// test that flags take precedence over the environment, which takes
+// precedence over the env file and then the defaults, and that bad command
+// lines are rejected
+func TestParseConfig(t *testing.T) {
+	env := map[string]string{
+		"CONSUMER_KEY":        "env-key",
+		"CONSUMER_SECRET":     "env-secret",
+		"CAMPUSAPI_CACHE_DIR": "/env/cache",
+	}
+	getenv := func(name string) string { return env[name] }
+	noEnvFile := filepath.Join(t.TempDir(), "missing.env")
+	envFile := filepath.Join(t.TempDir(), ".env")
+	err := os.WriteFile(envFile, []byte("CONSUMER_KEY=file-key\nCAMPUSAPI_TOKEN_URL=http://file-token\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultBaseUrl := activedirectory.VersionURL(activedirectory.DefaultVersion)
+
+	tests := []struct {
+		args []string
+		want *config // nil if the command line is rejected
+	}{
+		{[]string{"-env", noEnvFile, "user", "liame", "hvera"}, &config{
+			command: "user", args: []string{"liame", "hvera"},
+			consumerKey: "env-key", consumerSecret: "env-secret",
+			tokenUrl: REFRESH_TOKEN_URL, baseUrl: defaultBaseUrl,
+			cacheDir: "/env/cache", format: "json", timeout: 30 * time.Second,
+		}},
+		{[]string{"-env", noEnvFile, "-key", "k", "-secret", "s", "-token-url", "http://token", "-base-url", "http://base",
+			"-cache-dir", "/flag/cache", "-format", "csv", "-timeout", "5s", "-v", "get", "-"}, &config{
+			command: "get", args: []string{"-"},
+			consumerKey: "k", consumerSecret: "s",
+			tokenUrl: "http://token", baseUrl: "http://base",
+			cacheDir: "/flag/cache", format: "csv", timeout: 5 * time.Second, verbose: true,
+		}},
+		{[]string{"-env", noEnvFile, "token"}, &config{
+			command: "token", args: []string{},
+			consumerKey: "env-key", consumerSecret: "env-secret",
+			tokenUrl: REFRESH_TOKEN_URL, baseUrl: defaultBaseUrl,
+			cacheDir: "/env/cache", format: "json", timeout: 30 * time.Second,
+		}},
+		{[]string{"-env", envFile, "token"}, &config{
+			command: "token", args: []string{},
+			consumerKey: "env-key", consumerSecret: "env-secret",
+			tokenUrl: "http://file-token", baseUrl: defaultBaseUrl,
+			cacheDir: "/env/cache", format: "json", timeout: 30 * time.Second,
+		}},
+		{[]string{"-env", noEnvFile}, nil},
+		{[]string{"-env", noEnvFile, "delete", "liame"}, nil},
+		{[]string{"-env", noEnvFile, "-format", "xml", "get", "/users"}, nil},
+		{[]string{"-env", noEnvFile, "-bogus", "get"}, nil},
+	}
+
+	for _, test := range tests {
+		cfg, err := parseConfig(test.args, getenv, io.Discard)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %+v, want an error", test.args, cfg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg, test.want) {
+			t.Errorf("%q:\ngot  %+v\nwant %+v", test.args, cfg, test.want)
+		}
+	}
+
+	if value, set := os.LookupEnv("CAMPUSAPI_TOKEN_URL"); set {
+		t.Errorf("the env file set CAMPUSAPI_TOKEN_URL=%v in the process environment", value)
+	}
+
+	// caching on disk is opt-in
+	cfg, err := parseConfig([]string{"-env", noEnvFile, "token"}, func(string) string { return "" }, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.cacheDir != "" {
+		t.Errorf("got cache directory %q by default, want none", cfg.cacheDir)
+	}
+}
+
+// test that batch mode reads one argument per line from stdin, skipping
+// blank lines and comments, only when no arguments (or "-") are given
+func TestBatch(t *testing.T) {
+	stdin := "liame\n\n  hvera  \n# a comment\nsc73"
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"cabrooks"}, []string{"cabrooks"}},
+		{[]string{"cabrooks", "-"}, []string{"cabrooks", "-"}},
+		{nil, []string{"liame", "hvera", "sc73"}},
+		{[]string{"-"}, []string{"liame", "hvera", "sc73"}},
+	}
+
+	for _, test := range tests {
+		got, err := batch(test.args, strings.NewReader(stdin))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("batch(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+// test looking up users read from stdin, as CSV, with the netids not found
+// reported on stderr
+func TestRunUser(t *testing.T) {
+	server := apihelpertest.NewServer()
+	defer server.Close()
+
+	cfg := newTestConfig(t, server)
+	cfg.format = "csv"
+
+	var out, stderr bytes.Buffer
+	err := runUser(context.Background(), cfg, strings.NewReader("liame\nhvera\nnobody\n"), &out, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "campusapi: 1 of 3 netids not found\n"; stderr.String() != want {
+		t.Errorf("got stderr %q, want %q", stderr.String(), want)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "uid,universityid,displayname,mail,department,pustatus") ||
+		!strings.HasPrefix(lines[1], "liame,920000001,") || !strings.HasPrefix(lines[2], "hvera,920000002,") {
+		t.Errorf("got output\n%s", out.String())
+	}
+}
+
+// test that the cache persists across runs, so that cache-stats counts hits
+// for responses fetched by an earlier command, and that it reports when no
+// cache is configured
+func TestRunCacheStats(t *testing.T) {
+	server := apihelpertest.NewServer()
+	defer server.Close()
+
+	cfg := newTestConfig(t, server)
+	cfg.args = []string{"users/basic?uid=liame"}
+	path := apihelpertest.ActiveDirectoryPath + "/users/basic"
+
+	err := runGet(context.Background(), cfg, nil, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = runCacheStats(context.Background(), cfg, nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stats struct{ Hits, Misses, Entries int }
+	err = json.Unmarshal(out.Bytes(), &stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Hits != 1 || stats.Misses != 0 || stats.Entries != 1 {
+		t.Errorf("got stats %s", out.Bytes())
+	}
+	if n := server.Requests(path); n != 1 {
+		t.Errorf("server saw %v lookups, want 1", n)
+	}
+
+	cfg.cacheDir = ""
+	err = runCacheStats(context.Background(), cfg, nil, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "no cache configured") {
+		t.Errorf("got error %v without a cache directory, want no cache configured", err)
+	}
+}
+
+/******************************************************************************/
+/*                                 Helpers                                    */
+/******************************************************************************/
+
+// returns a configuration for the test server, caching in a new directory
+func newTestConfig(t *testing.T, server *apihelpertest.Server) *config {
+	return &config{
+		consumerKey:    apihelpertest.ConsumerKey,
+		consumerSecret: apihelpertest.ConsumerSecret,
+		tokenUrl:       server.TokenURL(),
+		baseUrl:        server.ActiveDirectoryURL(),
+		cacheDir:       t.TempDir(),
+		format:         "json",
+		timeout:        10 * time.Second,
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// writes records in the given format. records that are JSON objects become
+// rows with one column per key; columns lists the preferred column order,
+// and any other keys follow alphabetically.
+func writeRecords(w io.Writer, format string, records []interface{}, columns []string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if len(records) == 1 {
+			return enc.Encode(records[0])
+		}
+		return enc.Encode(records)
+	}
+
+	rows, header, err := tabulate(records, columns)
+	if err != nil {
+		return err
+	}
+
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.WriteAll(rows)
+		return cw.Error()
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for i, column := range header {
+		if i > 0 {
+			fmt.Fprint(tw, "\t")
+		}
+		fmt.Fprint(tw, column)
+	}
+	fmt.Fprintln(tw)
+	for _, row := range rows {
+		for i, cell := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// converts records into rows of cells under a common header
+func tabulate(records []interface{}, columns []string) (rows [][]string, header []string, err error) {
+	objects := make([]map[string]interface{}, len(records))
+	seen := map[string]bool{}
+	var extra []string
+
+	for i, record := range records {
+		// round-trip through JSON so that structs are keyed by their json tags
+		b, err := json.Marshal(record)
+		if err != nil {
+			return nil, nil, err
+		}
+		var object map[string]interface{}
+		if json.Unmarshal(b, &object) != nil {
+			object = map[string]interface{}{"value": record}
+		}
+		objects[i] = object
+
+		for key := range object {
+			if !seen[key] {
+				seen[key] = true
+				extra = append(extra, key)
+			}
+		}
+	}
+
+	for _, column := range columns {
+		if seen[column] {
+			header = append(header, column)
+			delete(seen, column)
+		}
+	}
+	sort.Strings(extra)
+	for _, key := range extra {
+		if seen[key] {
+			header = append(header, key)
+		}
+	}
+
+	for _, object := range objects {
+		row := make([]string, len(header))
+		for i, column := range header {
+			row[i] = cell(object[column])
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, header, nil
+}
+
+// formats a JSON value as a table cell
+func cell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+/******************************************************************************/
+/*                                  Tests                                     */
+/******************************************************************************/
+
+// test that records are written as JSON, aligned tables and CSV, with the
+// preferred columns first and the rest in alphabetical order
+func TestWriteRecords(t *testing.T) {
+	type user struct {
+		UID  string `json:"uid"`
+		Mail string `json:"mail"`
+	}
+	records := []interface{}{
+		user{UID: "liame", Mail: "liame@princeton.edu"},
+		map[string]interface{}{"uid": "hvera", "groups": []interface{}{"a", "b"}, "age": 20.0},
+	}
+
+	tests := []struct {
+		format  string
+		records []interface{}
+		columns []string
+		want    string
+	}{
+		{"json", records[:1], nil, "{\n  \"uid\": \"liame\",\n  \"mail\": \"liame@princeton.edu\"\n}\n"},
+		{"json", []interface{}{"a", 1.5}, nil, "[\n  \"a\",\n  1.5\n]\n"},
+		{"csv", records, []string{"uid"}, "uid,age,groups,mail\n" +
+			"liame,,,liame@princeton.edu\n" +
+			"hvera,20,\"[\"\"a\"\",\"\"b\"\"]\",\n"},
+		{"table", records, []string{"uid", "mail"}, "" +
+			"uid    mail                 age  groups\n" +
+			"liame  liame@princeton.edu       \n" +
+			"hvera                       20   [\"a\",\"b\"]\n"},
+		{"csv", []interface{}{"plain", nil}, nil, "value\nplain\n\n"},
+		{"csv", nil, nil, "\n"},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := writeRecords(&out, test.format, test.records, test.columns)
+		if err != nil {
+			t.Errorf("%v %v: %v", test.format, test.records, err)
+			continue
+		}
+		if out.String() != test.want {
+			t.Errorf("%v %v: got\n%s\nwant\n%s", test.format, test.records, out.String(), test.want)
+		}
+	}
+}
+
+// test the formatting of JSON values as table cells
+func TestCell(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, ""},
+		{"text", "text"},
+		{42.0, "42"},
+		{1.5, "1.5"},
+		{true, "true"},
+		{map[string]interface{}{"a": 1.0}, `{"a":1}`},
+		{[]interface{}{"x", nil}, `["x",null]`},
+	}
+
+	for _, test := range tests {
+		if got := cell(test.value); got != test.want {
+			t.Errorf("cell(%#v) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
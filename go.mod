@@ -3,4 +3,4 @@ module campus-api-helper
 // +heroku goVersion go1.18
 go 1.18
 
-require github.com/joho/godotenv v1.4.0 // indirect
+require github.com/joho/godotenv v1.4.0
@@ -0,0 +1,37 @@
+// Package promtext writes metrics in the Prometheus text exposition format,
+// for the cache and apihelper packages to share.
+package promtext
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// escapes a label value as the exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteHeader writes the HELP and TYPE lines that introduce a metric
+func WriteHeader(out *bufio.Writer, name, kind, help string) {
+	out.WriteString("# HELP " + name + " " + help + "\n")
+	out.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// WriteSample writes one sample; pairs alternates label names and values
+func WriteSample(out *bufio.Writer, name string, pairs []string, value float64) {
+	out.WriteString(name)
+	if len(pairs) > 0 {
+		out.WriteString("{")
+		for i := 0; i < len(pairs); i += 2 {
+			if i > 0 {
+				out.WriteString(",")
+			}
+			out.WriteString(pairs[i] + `="` + labelEscaper.Replace(pairs[i+1]) + `"`)
+		}
+		out.WriteString("}")
+	}
+	out.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
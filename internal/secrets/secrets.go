@@ -0,0 +1,24 @@
+// Package secrets lists the headers and fields whose values are credentials,
+// so that logs and recorded cassettes redact the same ones.
+package secrets
+
+// Redacted replaces the values of credentials
+const Redacted = "REDACTED"
+
+// Headers returns the canonical names of headers whose values are credentials
+func Headers() []string {
+	return []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+}
+
+// Fields returns the set of lowercase query parameter, form field and JSON
+// field names whose values are credentials
+func Fields() map[string]bool {
+	return map[string]bool{
+		"access_token":  true,
+		"client_secret": true,
+		"code":          true,
+		"password":      true,
+		"refresh_token": true,
+		"token":         true,
+	}
+}
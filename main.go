@@ -1,7 +1,9 @@
 package main
 
 import (
+	"campus-api-helper/activedirectory"
 	"campus-api-helper/apihelper"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -14,14 +16,13 @@ import (
 )
 
 const (
-	BASE_URL          = "https://api.princeton.edu:443/active-directory/1.0.5"
 	REFRESH_TOKEN_URL = "https://api.princeton.edu:443/token"
 )
 
-type Student struct {
-	UniversityId string `json:?universityid"`
-	UID          string `json:?uid"`
-}
+var BASE_URL = activedirectory.VersionURL(activedirectory.DefaultVersion)
+
+// logs requests, cache lookups and token refreshes, and the showcases' errors
+var logger = apihelper.NewTextLogger(os.Stderr, apihelper.LevelDebug)
 
 var netids []string = []string{"liame", "hvera", "sc73", "mtouil", "shmeyer", "cjcheng", "adogra", "cabrooks", "juliacw", "aalevy", "nk5635"}
 
@@ -35,44 +36,50 @@ func getStudentDo(apiHelper *apihelper.CampusAPIHelper, i int) {
 	netid := netids[i]
 	req, err := http.NewRequest(http.MethodGet, BASE_URL+"/users/basic?uid="+netid, nil)
 	if err != nil {
-		fmt.Printf("client: could not create request: %s\n", err)
+		logger.Error("could not create request", "netid", netid, "error", err)
+		return
 	}
 
 	res, err := apiHelper.Do(req)
 	if err != nil {
-		fmt.Printf("client: could not execute request: %s\n", err)
+		logger.Error("could not execute request", "netid", netid, "error", err)
+		return
 	}
 	defer res.Body.Close()
 
 	dec := json.NewDecoder(res.Body)
-	var s []Student
+	var s []activedirectory.User
 	err = dec.Decode(&s)
 	if err != nil {
-		fmt.Printf("client: could not decode json response body: %s\n", err)
+		logger.Error("could not decode json response body", "netid", netid, "error", err)
 	}
 
 	fmt.Printf("%#v \n", s)
 }
 
-// getStudentGet tests CampusAPIHelper's Get() method, which utilizes an LRU cache
+// getStudentGet tests CampusAPIHelper's Get() method, which utilizes an LRU cache,
+// through the typed Active Directory client
 func getStudentGet(apiHelper *apihelper.CampusAPIHelper) {
 
 	netid := netids[rand.Intn(len(netids))]
 
-	res, err := apiHelper.Get(BASE_URL + "/users/basic?uid=" + netid)
+	s, err := activedirectory.NewClient(apiHelper, BASE_URL).GetUserBasic(context.Background(), netid)
 	if err != nil {
-		fmt.Printf("client: could not execute request: %s\n", err)
+		logger.Error("could not look up user", "netid", netid, "error", err)
+		return
 	}
-	defer res.Body.Close()
 
-	dec := json.NewDecoder(res.Body)
-	var s []Student
-	err = dec.Decode(&s)
-	if err != nil {
-		fmt.Printf("client: could not decode json response body: %s\n", err)
-	}
+	fmt.Printf("%#v \n", *s)
+}
 
-	fmt.Printf("%#v \n", s)
+// creates a CampusAPIHelper with a cache of cacheSize bytes that logs to logger
+func newHelper(consumerKey string, consumerSecret string, cacheSize int) (*apihelper.CampusAPIHelper, error) {
+	return apihelper.New(
+		apihelper.WithClientCredentials(consumerKey, consumerSecret, REFRESH_TOKEN_URL),
+		apihelper.WithCacheSize(cacheSize),
+		apihelper.WithEagerToken(),
+		apihelper.WithLogger(logger),
+	)
 }
 
 /******************************************************************************/
@@ -87,7 +94,7 @@ func ShowcaseDo() {
 	consumerKey := os.Getenv("CONSUMER_KEY")
 	consumerSecret := os.Getenv("CONSUMER_SECRET")
 
-	testHelper, err := apihelper.NewCampusAPIHelper(consumerKey, consumerSecret, REFRESH_TOKEN_URL, nil, 100000)
+	testHelper, err := newHelper(consumerKey, consumerSecret, 100000)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -124,7 +131,7 @@ func ShowcaseGet() {
 	consumerKey := os.Getenv("CONSUMER_KEY")
 	consumerSecret := os.Getenv("CONSUMER_SECRET")
 
-	testHelper, err := apihelper.NewCampusAPIHelper(consumerKey, consumerSecret, REFRESH_TOKEN_URL, nil, 100000)
+	testHelper, err := newHelper(consumerKey, consumerSecret, 100000)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -163,7 +170,7 @@ func ShowcaseEviction() {
 	consumerKey := os.Getenv("CONSUMER_KEY")
 	consumerSecret := os.Getenv("CONSUMER_SECRET")
 
-	testHelper, err := apihelper.NewCampusAPIHelper(consumerKey, consumerSecret, REFRESH_TOKEN_URL, nil, 10000)
+	testHelper, err := newHelper(consumerKey, consumerSecret, 10000)
 	if err != nil {
 		log.Fatalln(err)
 	}